@@ -0,0 +1,198 @@
+// Package auth configures the access to private git and HTTP servers:
+// tokens, headers, client certificates and CA bundles per host.
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"do3b/xltemplate/api/redact"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FileName is the name of the credentials file,
+	// in the xltemplate configuration directory.
+	FileName = "credentials.yaml"
+	// PathEnv overrides the path of the credentials file.
+	PathEnv = "XLTEMPLATE_CREDENTIALS"
+	// TokenEnvPrefix prefixes the environment variables holding the
+	// token of a host, e.g. XLTEMPLATE_TOKEN_github_com.
+	TokenEnvPrefix = "XLTEMPLATE_TOKEN_"
+
+	// defaultUsername is the user name of the basic authentication
+	// of git, when only a token is given. Servers ignore it or accept
+	// any name for tokens, e.g. GitHub and GitLab.
+	defaultUsername = "x-access-token"
+)
+
+// Host is the configuration of a host.
+type Host struct {
+	// Token authenticates the requests, as a bearer token for HTTP files
+	// and as the password of the basic authentication for git.
+	Token string `yaml:"token,omitempty"`
+	// TokenEnv names the environment variable holding the token.
+	TokenEnv string `yaml:"tokenEnv,omitempty"`
+	// Username, if set, makes HTTP files use basic authentication
+	// with the token as password.
+	Username string `yaml:"username,omitempty"`
+	// Headers are added to the requests, e.g. PRIVATE-TOKEN.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// ClientCert and ClientKey are the PEM files of
+	// the client certificate of TLS connections.
+	ClientCert string `yaml:"clientCert,omitempty"`
+	ClientKey  string `yaml:"clientKey,omitempty"`
+	// CABundle is a PEM file of the certificate authorities trusted
+	// for the host. HTTP files still trust the system ones, git only
+	// trusts the bundle.
+	CABundle string `yaml:"caBundle,omitempty"`
+}
+
+// Credentials is the configuration of the hosts, keyed by host name,
+// with an optional port.
+type Credentials struct {
+	Hosts map[string]*Host `yaml:"hosts,omitempty"`
+}
+
+// DefaultPath returns $XLTEMPLATE_CREDENTIALS, or the credentials
+// file of the xltemplate directory of the user configuration
+// directory of the platform, e.g. ~/.config/xltemplate.
+func DefaultPath() (string, error) {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "xltemplate", FileName), nil
+}
+
+// Load reads the credentials file at path, a missing file yields no
+// credentials, and adds the tokens of the environment. The secrets are
+// recorded to be redacted from errors and logs.
+func Load(path string) (*Credentials, error) {
+	c := &Credentials{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	if err == nil {
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal credentials file %s: %w", path, err)
+		}
+	}
+	if c.Hosts == nil {
+		c.Hosts = map[string]*Host{}
+	}
+	for name, host := range c.Hosts {
+		if host == nil {
+			host = &Host{}
+			c.Hosts[name] = host
+		}
+		if host.TokenEnv != "" && host.Token == "" {
+			host.Token = os.Getenv(host.TokenEnv)
+		}
+		resolvePath(&host.ClientCert, path)
+		resolvePath(&host.ClientKey, path)
+		resolvePath(&host.CABundle, path)
+	}
+	for _, env := range os.Environ() {
+		name, token, _ := strings.Cut(env, "=")
+		if len(name) <= len(TokenEnvPrefix) || !strings.EqualFold(name[:len(TokenEnvPrefix)], TokenEnvPrefix) || token == "" {
+			continue
+		}
+		hostName := c.hostOfEnv(name[len(TokenEnvPrefix):])
+		if c.Hosts[hostName] == nil {
+			c.Hosts[hostName] = &Host{}
+		}
+		c.Hosts[hostName].Token = token
+	}
+
+	for _, host := range c.Hosts {
+		redact.Add(host.Token, strings.TrimPrefix(host.GitAuthorization(), "Basic "))
+		for _, value := range host.Headers {
+			redact.Add(value)
+		}
+	}
+	return c, nil
+}
+
+// hostOfEnv returns the host a token variable suffix refers to: the
+// configured host it matches, once dots and dashes are replaced by
+// underscores, or the suffix with underscores turned into dots.
+func (c *Credentials) hostOfEnv(suffix string) string {
+	for name := range c.Hosts {
+		if strings.EqualFold(envSuffix(name), suffix) {
+			return name
+		}
+	}
+	return strings.ToLower(strings.ReplaceAll(suffix, "_", "."))
+}
+
+func envSuffix(host string) string {
+	return strings.NewReplacer(".", "_", "-", "_", ":", "_").Replace(host)
+}
+
+// resolvePath makes a path relative to the credentials file absolute.
+func resolvePath(path *string, credentialsPath string) {
+	if *path == "" || filepath.IsAbs(*path) {
+		return
+	}
+	if dir, err := filepath.Abs(filepath.Dir(credentialsPath)); err == nil {
+		*path = filepath.Join(dir, *path)
+	}
+}
+
+// Lookup returns the configuration of a host, given as host or host:port,
+// or nil. The configuration of the host name applies to every port.
+func (c *Credentials) Lookup(host string) *Host {
+	if c == nil {
+		return nil
+	}
+	if h, ok := c.Hosts[strings.ToLower(host)]; ok {
+		return h
+	}
+	if name, _, found := strings.Cut(host, ":"); found {
+		return c.Hosts[strings.ToLower(name)]
+	}
+	return nil
+}
+
+func (h *Host) username() string {
+	if h.Username != "" {
+		return h.Username
+	}
+	return defaultUsername
+}
+
+// basicAuth returns the value of the Authorization header of
+// the basic authentication with the token, or an empty string.
+func (h *Host) basicAuth(username string) string {
+	if h.Token == "" {
+		return ""
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+h.Token))
+}
+
+// HTTPAuthorization returns the Authorization header of the HTTP
+// files: basic if a user name is set, bearer otherwise.
+func (h *Host) HTTPAuthorization() string {
+	if h.Token == "" {
+		return ""
+	}
+	if h.Username != "" {
+		return h.basicAuth(h.Username)
+	}
+	return "Bearer " + h.Token
+}
+
+// GitAuthorization returns the Authorization header of the
+// git smart HTTP protocol, which expects basic authentication.
+func (h *Host) GitAuthorization() string {
+	return h.basicAuth(h.username())
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const gitConfigCountEnv = "GIT_CONFIG_COUNT"
+
+// GitEnv returns the environment of the git commands, configuring the
+// settings of the hosts for their https URLs, or nil if there are none.
+// The settings go through the environment rather than the command line,
+// so that they don't show in the process list nor in the errors.
+func (c *Credentials) GitEnv() []string {
+	if c == nil || len(c.Hosts) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(c.Hosts))
+	for name := range c.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var config [][2]string
+	for _, name := range names {
+		host := c.Hosts[name]
+		section := "http.https://" + name + "/."
+		if value := host.GitAuthorization(); value != "" {
+			config = append(config, [2]string{section + "extraHeader", "Authorization: " + value})
+		}
+		headers := make([]string, 0, len(host.Headers))
+		for header := range host.Headers {
+			headers = append(headers, header)
+		}
+		sort.Strings(headers)
+		for _, header := range headers {
+			config = append(config, [2]string{section + "extraHeader", header + ": " + host.Headers[header]})
+		}
+		if host.ClientCert != "" {
+			config = append(config, [2]string{section + "sslCert", host.ClientCert})
+		}
+		if host.ClientKey != "" {
+			config = append(config, [2]string{section + "sslKey", host.ClientKey})
+		}
+		if host.CABundle != "" {
+			config = append(config, [2]string{section + "sslCAInfo", host.CABundle})
+		}
+	}
+	if len(config) == 0 {
+		return nil
+	}
+
+	// Keep the configuration already given in the environment.
+	env := os.Environ()
+	count := 0
+	for i, variable := range env {
+		if value, found := strings.CutPrefix(variable, gitConfigCountEnv+"="); found {
+			count, _ = strconv.Atoi(value)
+			env = append(env[:i], env[i+1:]...)
+			break
+		}
+	}
+	for _, entry := range config {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", count, entry[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", count, entry[1]))
+		count++
+	}
+	return append(env, fmt.Sprintf("%s=%d", gitConfigCountEnv, count))
+}
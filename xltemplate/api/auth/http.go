@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"do3b/xltemplate/api/redact"
+)
+
+// HTTPClient returns a client adding the headers and TLS settings of
+// the hosts to the HTTPS requests of next, for HTTP files.
+func (c *Credentials) HTTPClient(next *http.Client) *http.Client {
+	if next == nil {
+		next = &http.Client{}
+	}
+	client := *next
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = &authTransport{
+		credentials:   c,
+		next:          transport,
+		tlsTransports: map[*Host]http.RoundTripper{},
+	}
+	return &client
+}
+
+type authTransport struct {
+	credentials *Credentials
+	next        http.RoundTripper
+
+	mu sync.Mutex
+	// tlsTransports are the transports of the hosts with TLS settings.
+	tlsTransports map[*Host]http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Credentials are never sent in clear text over http://.
+	if req.URL.Scheme != "https" {
+		return t.next.RoundTrip(req)
+	}
+	host := t.credentials.Lookup(req.URL.Host)
+	if host == nil {
+		return t.next.RoundTrip(req)
+	}
+	transport, err := t.transport(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round trippers must not modify the request.
+	req = req.Clone(req.Context())
+	if value := host.HTTPAuthorization(); value != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", value)
+	}
+	for name, value := range host.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := transport.RoundTrip(req)
+	return resp, redact.Error(err)
+}
+
+// transport returns the transport of a host, next
+// unless the host has TLS settings.
+func (t *authTransport) transport(host *Host) (http.RoundTripper, error) {
+	if host.ClientCert == "" && host.CABundle == "" {
+		return t.next, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if transport, ok := t.tlsTransports[host]; ok {
+		return transport, nil
+	}
+	base, ok := t.next.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("TLS settings of the credentials need an HTTP transport, got %T", t.next)
+	}
+	tlsConfig, err := host.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := base.Clone()
+	transport.TLSClientConfig = tlsConfig
+	t.tlsTransports[host] = transport
+	return transport, nil
+}
+
+// TLSConfig returns the TLS configuration of the host, with its
+// client certificate and CA bundle if any.
+func (h *Host) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if h.ClientCert != "" || h.ClientKey != "" {
+		certificate, err := tls.LoadX509KeyPair(h.ClientCert, h.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	if h.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		bundle, err := os.ReadFile(h.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", h.CABundle)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,120 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"do3b/xltemplate/api/auth"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Names of the backends.
+const (
+	// BackendExec runs the git binary found on the path.
+	BackendExec = "exec"
+	// BackendBuiltin is a pure Go implementation of git,
+	// for environments without git binary.
+	BackendBuiltin = "builtin"
+)
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// Backend runs the git operations of the cloners.
+type Backend interface {
+	// Fetch fetches the repo at its ref, and its submodules if asked
+	// to, in dir, an existing empty directory. It returns the commit
+	// checked out.
+	Fetch(repoSpec *RepoSpec, dir filesys.ConfirmedDir) (string, error)
+
+	// ResolveRef returns the commit the ref of the repo points to, without
+	// fetching it. Full commit hashes are returned as is. An empty commit
+	// is returned when the ref is not advertised by the remote, e.g. for
+	// an abbreviated commit hash.
+	ResolveRef(repoSpec *RepoSpec) (string, error)
+}
+
+// NewBackend returns the backend with the given name, the exec
+// backend if the name is empty, authenticating with the credentials
+// of the hosts, which may be nil.
+func NewBackend(name string, credentials *auth.Credentials) (Backend, error) {
+	switch name {
+	case "", BackendExec:
+		return execBackend{credentials: credentials}, nil
+	case BackendBuiltin:
+		return newBuiltinBackend(credentials), nil
+	default:
+		return nil, fmt.Errorf("unknown git backend %q, expected %q or %q", name, BackendExec, BackendBuiltin)
+	}
+}
+
+// execBackend runs the git binary.
+type execBackend struct {
+	credentials *auth.Credentials
+}
+
+func (b execBackend) Fetch(repoSpec *RepoSpec, dir filesys.ConfirmedDir) (string, error) {
+	r, err := newCmdRunnerInDir(dir, repoSpec.Timeout)
+	if err != nil {
+		return "", err
+	}
+	r.env = b.credentials.GitEnv()
+	if err = cloneWithRunner(r, repoSpec); err != nil {
+		return "", err
+	}
+	out, err := r.output("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (b execBackend) ResolveRef(repoSpec *RepoSpec) (string, error) {
+	ref := repoSpec.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if commitPattern.MatchString(ref) {
+		return ref, nil
+	}
+	r, err := newCmdRunnerInDir("", repoSpec.Timeout)
+	if err != nil {
+		return "", err
+	}
+	r.env = b.credentials.GitEnv()
+	out, err := r.output("ls-remote", repoSpec.CloneSpec(), ref, ref+"^{}")
+	if err != nil {
+		return "", err
+	}
+
+	// Annotated tags are listed twice when asking for the peeled
+	// ref, the line ending with ^{} holds the commit.
+	commit := ""
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		hash, name, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		if strings.HasSuffix(name, "^{}") {
+			return hash, nil
+		}
+		if commit == "" {
+			commit = hash
+		}
+	}
+	return commit, nil
+}
+
+// HeadCommit returns the commit checked out in the clone of the repo.
+func HeadCommit(repoSpec *RepoSpec) (string, error) {
+	r, err := newCmdRunnerInDir(repoSpec.Dir, repoSpec.Timeout)
+	if err != nil {
+		return "", err
+	}
+	out, err := r.output("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
@@ -0,0 +1,347 @@
+package git
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const (
+	// cacheStagingDir holds the clones in progress, moved
+	// to their entry once complete.
+	cacheStagingDir = ".staging"
+	// stagingGracePeriod is the age from which Prune takes the clones
+	// of the staging directory for leftovers of interrupted clones,
+	// rather than clones in progress of a concurrent build.
+	stagingGracePeriod = 24 * time.Hour
+	// noSubmodulesSuffix marks the entries cloned without submodules.
+	noSubmodulesSuffix = "-nosubmodules"
+	// sparseInfix marks the entries limited to a directory,
+	// followed by a hash of the path they were cloned for.
+	sparseInfix = "-sparse-"
+)
+
+// Cache stores clones of git repositories on disk, keyed by host,
+// repository path and commit, so builds reuse them instead of
+// fetching the same commit again.
+type Cache struct {
+	Dir string
+	// Backend fetches the repositories, the exec backend if nil.
+	Backend Backend
+}
+
+// CacheEntry is a clone stored in the cache.
+type CacheEntry struct {
+	Host       string
+	RepoPath   string
+	Commit     string
+	Submodules bool
+	Sparse     bool // a single directory is checked out
+	Dir        string
+	LastUsed   time.Time
+	Size       int64
+}
+
+// DefaultCacheDir returns $XDG_CACHE_HOME/xltemplate, or the
+// xltemplate directory of the user cache directory of the platform.
+func DefaultCacheDir() (string, error) {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "xltemplate"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "xltemplate"), nil
+}
+
+// NewCache returns a cache stored in dir.
+func NewCache(dir string) *Cache {
+	return &Cache{Dir: dir}
+}
+
+// Cloner returns a cloner serving clones from the cache. The ref is
+// resolved to a commit by the backend, e.g. with git ls-remote; on a
+// miss, the repo is fetched by the backend and stored. Cached clones are shared, so they
+// are marked persistent and loaders do not remove them.
+func (c *Cache) Cloner() Cloner {
+	return func(repoSpec *RepoSpec) error {
+		commit, err := c.backend().ResolveRef(repoSpec)
+		if err != nil {
+			return err
+		}
+		if commit != "" {
+			// A full clone holds every path, otherwise
+			// look for one limited to this path.
+			for _, sparse := range []bool{false, true} {
+				if sparse && repoSpec.sparsePath() == "" {
+					continue
+				}
+				dir := c.entryDir(repoSpec, commit, sparse)
+				if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+					continue
+				}
+				touch(dir)
+				repoSpec.Dir = filesys.ConfirmedDir(dir)
+				repoSpec.Commit = commit
+				repoSpec.Persistent = true
+				if sparse {
+					repoSpec.SparseDir = sparseDirOf(dir, repoSpec)
+				}
+				return nil
+			}
+		}
+		return c.clone(repoSpec)
+	}
+}
+
+// clone clones the repo in the staging directory of the cache, then
+// moves it to the entry of the commit it was checked out at.
+func (c *Cache) clone(repoSpec *RepoSpec) error {
+	stagingDir := filepath.Join(c.Dir, cacheStagingDir)
+	if err := os.MkdirAll(stagingDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(stagingDir, "clone-")
+	if err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	commit, err := c.backend().Fetch(repoSpec, filesys.ConfirmedDir(tmpDir))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+
+	dir := c.entryDir(repoSpec, commit, repoSpec.SparseDir != "")
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		// Another build stored the same commit meanwhile.
+		os.RemoveAll(tmpDir)
+		if _, statErr := os.Stat(filepath.Join(dir, ".git")); statErr != nil {
+			return fmt.Errorf("failed to store clone in cache: %w", err)
+		}
+	}
+	repoSpec.Dir = filesys.ConfirmedDir(dir)
+	repoSpec.Commit = commit
+	repoSpec.Persistent = true
+	return nil
+}
+
+func (c *Cache) backend() Backend {
+	if c.Backend == nil {
+		return execBackend{}
+	}
+	return c.Backend
+}
+
+// entryDir returns the directory of the entry of a repo at a commit,
+// checked out entirely or limited to the directory of its path.
+func (c *Cache) entryDir(repoSpec *RepoSpec, commit string, sparse bool) string {
+	name := commit
+	if !repoSpec.Submodules {
+		name += noSubmodulesSuffix
+	}
+	if sparse {
+		name += sparseInfix + repoSpec.sparseKey()
+	}
+	return filepath.Join(c.Dir, repoSpec.LocalPath(), name)
+}
+
+// LocalPath returns a relative path made of the host and the
+// repository path, to store the repository on disk.
+func (x *RepoSpec) LocalPath() string {
+	return filepath.Join(hostKey(x.Host), filepath.FromSlash(repoPathKey(x.RepoPath)))
+}
+
+// SanitizeName returns name as a single path element safe to store on disk,
+// replacing the characters other than letters, digits, '-', '_' and '.'.
+func SanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if name == "" || name == "." || name == ".." || name == cacheStagingDir {
+		name = "_" + name
+	}
+	return name
+}
+
+// List returns the entries of the cache, sorted by host, repository and commit.
+func (c *Cache) List() ([]CacheEntry, error) {
+	var entries []CacheEntry
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == c.Dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() || path == c.Dir {
+			return nil
+		}
+		if d.Name() == cacheStagingDir {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			return nil
+		}
+		entry, ok := c.entry(path)
+		if ok {
+			entries = append(entries, entry)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Host != entries[j].Host {
+			return entries[i].Host < entries[j].Host
+		}
+		if entries[i].RepoPath != entries[j].RepoPath {
+			return entries[i].RepoPath < entries[j].RepoPath
+		}
+		return entries[i].Commit < entries[j].Commit
+	})
+	return entries, nil
+}
+
+func (c *Cache) entry(dir string) (CacheEntry, bool) {
+	rel, err := filepath.Rel(c.Dir, dir)
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) < 3 {
+		return CacheEntry{}, false
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	commit, _, sparse := strings.Cut(parts[len(parts)-1], sparseInfix)
+	submodules := !strings.HasSuffix(commit, noSubmodulesSuffix)
+	return CacheEntry{
+		Host:       parts[0],
+		RepoPath:   strings.Join(parts[1:len(parts)-1], "/"),
+		Commit:     strings.TrimSuffix(commit, noSubmodulesSuffix),
+		Submodules: submodules,
+		Sparse:     sparse,
+		Dir:        dir,
+		LastUsed:   info.ModTime(),
+		Size:       dirSize(dir),
+	}, true
+}
+
+// Prune removes the entries not used since the given duration,
+// and the leftovers of interrupted clones older than a day. It
+// returns the removed entries.
+func (c *Cache) Prune(unusedFor time.Duration) ([]CacheEntry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []CacheEntry
+	limit := time.Now().Add(-unusedFor)
+	for _, entry := range entries {
+		if entry.LastUsed.After(limit) {
+			continue
+		}
+		if err := os.RemoveAll(entry.Dir); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, entry)
+	}
+	return pruned, c.pruneStaging(time.Now().Add(-stagingGracePeriod))
+}
+
+// pruneStaging removes the clones of the staging directory
+// created before limit, and the directory once empty.
+func (c *Cache) pruneStaging(limit time.Time) error {
+	stagingDir := filepath.Join(c.Dir, cacheStagingDir)
+	clones, err := os.ReadDir(stagingDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, clone := range clones {
+		info, err := clone.Info()
+		if err != nil || info.ModTime().After(limit) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(stagingDir, clone.Name())); err != nil {
+			return err
+		}
+	}
+	// Fails while a clone is in progress.
+	os.Remove(stagingDir)
+	return nil
+}
+
+// Clear removes every entry of the cache.
+func (c *Cache) Clear() error {
+	return os.RemoveAll(c.Dir)
+}
+
+// hostKey turns a host, e.g. https://github.com/ or
+// git@github.com:, into a directory name.
+func hostKey(host string) string {
+	scheme, rest, found := strings.Cut(host, "://")
+	if !found {
+		rest = scheme
+		scheme = ""
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	rest = strings.Trim(rest, "/:")
+	if rest == "" {
+		rest = scheme
+	}
+	return SanitizeName(rest)
+}
+
+// repoPathKey turns a repository path into a relative slash separated path.
+func repoPathKey(repoPath string) string {
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), gitSuffix)
+	parts := strings.Split(repoPath, "/")
+	for i, part := range parts {
+		parts[i] = SanitizeName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// touch records the use of an entry, for Prune.
+func touch(dir string) {
+	now := time.Now()
+	_ = os.Chtimes(dir, now, now)
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
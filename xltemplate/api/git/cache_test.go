@@ -0,0 +1,36 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPruneStaging pins that Prune leaves the clones in progress of
+// concurrent builds, and removes the leftovers of interrupted clones.
+func TestPruneStaging(t *testing.T) {
+	cache := NewCache(t.TempDir())
+	stagingDir := filepath.Join(cache.Dir, cacheStagingDir)
+	inProgress := filepath.Join(stagingDir, "clone-1")
+	leftover := filepath.Join(stagingDir, "clone-2")
+	for _, dir := range []string{inProgress, leftover} {
+		if err := os.MkdirAll(filepath.Join(dir, ".git"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-stagingGracePeriod - time.Hour)
+	if err := os.Chtimes(leftover, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Prune(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(inProgress); err != nil {
+		t.Errorf("clone in progress removed: %v", err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("leftover clone kept: %v", err)
+	}
+}
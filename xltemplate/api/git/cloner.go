@@ -4,6 +4,10 @@
 package git
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
@@ -14,11 +18,37 @@ type Cloner func(repoSpec *RepoSpec) error
 // to say, some remote API, to obtain a local clone of
 // a remote repo.
 func ClonerUsingGitExec(repoSpec *RepoSpec) error {
-	r, err := newCmdRunner(repoSpec.Timeout)
-	if err != nil {
-		return err
+	return ClonerUsing(execBackend{})(repoSpec)
+}
+
+// ClonerUsingGoGit obtains a local clone of a remote repo
+// without git install, with a pure Go implementation.
+func ClonerUsingGoGit(repoSpec *RepoSpec) error {
+	return ClonerUsing(newBuiltinBackend(nil))(repoSpec)
+}
+
+// ClonerUsing returns a cloner fetching repos with the
+// backend, each in a new temporary directory.
+func ClonerUsing(backend Backend) Cloner {
+	return func(repoSpec *RepoSpec) error {
+		dir, err := filesys.NewTmpConfirmedDir()
+		if err != nil {
+			return err
+		}
+		repoSpec.Dir = dir
+		commit, err := backend.Fetch(repoSpec, dir)
+		if err != nil {
+			return err
+		}
+		repoSpec.Commit = commit
+		return nil
 	}
-	repoSpec.Dir = r.dir
+}
+
+// cloneWithRunner fetches the repo at the requested ref
+// in the directory of the runner.
+func cloneWithRunner(r *gitRunner, repoSpec *RepoSpec) error {
+	var err error
 	if err = r.run("init"); err != nil {
 		return err
 	}
@@ -30,17 +60,77 @@ func ClonerUsingGitExec(repoSpec *RepoSpec) error {
 	if repoSpec.Ref != "" {
 		ref = repoSpec.Ref
 	}
-	// we use repoSpec.CloneSpec() instead of origin because on error,
-	// the prior prints the actual repo url for the user.
-	if err = r.run("fetch", "--depth=1", repoSpec.CloneSpec(), ref); err != nil {
+	if err = fetchRef(r, repoSpec, ref); err != nil {
+		return err
+	}
+	if err = sparseCheckout(r, repoSpec); err != nil {
 		return err
 	}
 	if err = r.run("checkout", "FETCH_HEAD"); err != nil {
 		return err
 	}
 	if repoSpec.Submodules {
-		return r.run("submodule", "update", "--init", "--recursive")
+		args := []string{"submodule", "update", "--init", "--recursive"}
+		if repoSpec.SparseDir != "" {
+			// Only the submodules in the checkout.
+			args = append(args, "--", repoSpec.SparseDir)
+		}
+		return r.run(args...)
+	}
+	return nil
+}
+
+// fetchRef fetches the ref. When the checkout can be limited to a path,
+// the files are left out of a partial clone, and only the ones checked
+// out are fetched afterwards.
+func fetchRef(r *gitRunner, repoSpec *RepoSpec, ref string) error {
+	if repoSpec.sparsePath() != "" {
+		// Servers not supporting filters send every file. The
+		// partial clone needs a named remote to fetch files from.
+		err := r.run("fetch", "--depth=1", "--filter=blob:none", "origin", ref)
+		if err == nil {
+			return nil
+		}
+		// e.g. git before 2.19, fall back to a full fetch.
+		_ = r.run("config", "--unset", "remote.origin.promisor")
+		_ = r.run("config", "--unset", "remote.origin.partialclonefilter")
+	}
+	// we use repoSpec.CloneSpec() instead of origin because on error,
+	// the prior prints the actual repo url for the user.
+	return r.run("fetch", "--depth=1", repoSpec.CloneSpec(), ref)
+}
+
+// sparseCheckout limits the checkout to the directory of the path
+// in the repo, if any, and records it in repoSpec.SparseDir.
+func sparseCheckout(r *gitRunner, repoSpec *RepoSpec) error {
+	p := repoSpec.sparsePath()
+	if p == "" {
+		return nil
+	}
+	out, err := r.output("ls-tree", "FETCH_HEAD", "--", p)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		// Missing path, reported by the loader.
+		return nil
+	}
+	dir := sparseDir(p, fields[1] != "blob")
+	if dir == "" {
+		return nil
+	}
+	file := filepath.Join(r.dir.String(), ".git", "info", "sparse-checkout")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, []byte("/"+dir+"/\n"), 0o644); err != nil {
+		return err
+	}
+	if err := r.run("config", "core.sparseCheckout", "true"); err != nil {
+		return err
 	}
+	repoSpec.SparseDir = dir
 	return nil
 }
 
@@ -4,9 +4,11 @@
 package git
 
 import (
+	"context"
 	"os/exec"
 	"time"
 
+	"do3b/xltemplate/api/redact"
 	"do3b/xltemplate/api/utils"
 
 	"sigs.k8s.io/kustomize/kyaml/errors"
@@ -18,19 +20,17 @@ type gitRunner struct {
 	gitProgram string
 	duration   time.Duration
 	dir        filesys.ConfirmedDir
+	// env is the environment of the commands, the one of the process if nil.
+	env []string
 }
 
-// newCmdRunner returns a gitRunner if it can find the binary.
-// It also creats a temp directory for cloning repos.
-func newCmdRunner(timeout time.Duration) (*gitRunner, error) {
+// newCmdRunnerInDir returns a gitRunner running
+// in the given directory, which must exist.
+func newCmdRunnerInDir(dir filesys.ConfirmedDir, timeout time.Duration) (*gitRunner, error) {
 	gitProgram, err := exec.LookPath("git")
 	if err != nil {
 		return nil, errors.WrapPrefixf(err, "no 'git' program on path")
 	}
-	dir, err := filesys.NewTmpConfirmedDir()
-	if err != nil {
-		return nil, err
-	}
 	return &gitRunner{
 		gitProgram: gitProgram,
 		duration:   timeout,
@@ -38,12 +38,51 @@ func newCmdRunner(timeout time.Duration) (*gitRunner, error) {
 	}, nil
 }
 
-// run a command with a timeout.
-func (r gitRunner) run(args ...string) error {
+// command returns the command running git with args. It is killed once
+// the timeout is hit, so that it does not outlive a timed out call, e.g.
+// when the call is retried.
+func (r gitRunner) command(args ...string) (*exec.Cmd, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.duration)
 	//nolint: gosec
-	cmd := exec.Command(r.gitProgram, args...)
+	cmd := exec.CommandContext(ctx, r.gitProgram, args...)
 	cmd.Dir = r.dir.String()
-	return utils.TimedCall(
+	cmd.Env = r.env
+	// Don't wait for the helpers of git holding its output once killed.
+	cmd.WaitDelay = time.Second
+	return cmd, cancel
+}
+
+// output runs a command with a timeout and returns its standard output.
+func (r gitRunner) output(args ...string) (string, error) {
+	cmd, cancel := r.command(args...)
+	defer cancel()
+	var out []byte
+	err := utils.TimedCall(
+		cmd.String(),
+		r.duration,
+		func() error {
+			var err error
+			out, err = cmd.Output()
+			if err != nil {
+				var stderr []byte
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					stderr = exitErr.Stderr
+				}
+				return errors.WrapPrefixf(err, "failed to run '%s': %s", cmd.String(), string(stderr))
+			}
+			return nil
+		})
+	if err != nil {
+		return "", redact.Error(err)
+	}
+	return string(out), nil
+}
+
+// run a command with a timeout.
+func (r gitRunner) run(args ...string) error {
+	cmd, cancel := r.command(args...)
+	defer cancel()
+	return redact.Error(utils.TimedCall(
 		cmd.String(),
 		r.duration,
 		func() error {
@@ -52,5 +91,5 @@ func (r gitRunner) run(args ...string) error {
 				return errors.WrapPrefixf(err, "failed to run '%s': %s", cmd.String(), string(out))
 			}
 			return err
-		})
+		}))
 }
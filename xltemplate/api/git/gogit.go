@@ -0,0 +1,390 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"do3b/xltemplate/api/auth"
+	"do3b/xltemplate/api/redact"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// fetchedRef is the local reference a commit fetched by hash is stored at.
+const fetchedRef = plumbing.ReferenceName("refs/xltemplate/fetched")
+
+const gitmodulesFile = ".gitmodules"
+
+// installFileProtocol replaces the file transport of go-git, which runs
+// git-upload-pack, by a server of the local repositories in process, so
+// that no git binary is needed. go-git only allows to set transports for
+// the whole process, so it is done once a builtin backend is used.
+var installFileProtocol = sync.OnceFunc(func() {
+	client.InstallProtocol("file", server.NewServer(localLoader{}))
+})
+
+// builtinBackend implements git in Go with go-git.
+type builtinBackend struct {
+	credentials *auth.Credentials
+}
+
+// newBuiltinBackend returns a builtin backend authenticating with the
+// credentials, set on each call for the host of the remote.
+func newBuiltinBackend(credentials *auth.Credentials) builtinBackend {
+	installFileProtocol()
+	return builtinBackend{credentials: credentials}
+}
+
+// remoteAuth holds the credentials of the host of a remote,
+// as go-git takes them in the options of the calls.
+type remoteAuth struct {
+	auth       transport.AuthMethod
+	caBundle   []byte
+	clientCert []byte
+	clientKey  []byte
+}
+
+// remoteAuth returns the credentials of the host of a remote URL,
+// none unless the URL is https.
+func (b builtinBackend) remoteAuth(remoteURL string) (remoteAuth, error) {
+	var ra remoteAuth
+	u, err := url.Parse(remoteURL)
+	if err != nil || u.Scheme != "https" {
+		return ra, nil
+	}
+	host := b.credentials.Lookup(u.Host)
+	if host == nil {
+		return ra, nil
+	}
+	if host.GitAuthorization() != "" || len(host.Headers) > 0 {
+		ra.auth = hostAuth{host: host}
+	}
+	if host.CABundle != "" {
+		if ra.caBundle, err = os.ReadFile(host.CABundle); err != nil {
+			return ra, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+	}
+	if host.ClientCert != "" || host.ClientKey != "" {
+		if ra.clientCert, err = os.ReadFile(host.ClientCert); err != nil {
+			return ra, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		if ra.clientKey, err = os.ReadFile(host.ClientKey); err != nil {
+			return ra, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+	}
+	return ra, nil
+}
+
+func (ra remoteAuth) listOptions() *gogit.ListOptions {
+	return &gogit.ListOptions{
+		Auth:       ra.auth,
+		CABundle:   ra.caBundle,
+		ClientCert: ra.clientCert,
+		ClientKey:  ra.clientKey,
+	}
+}
+
+// hostAuth adds the authorization and the headers of
+// a host to the git smart HTTP requests.
+type hostAuth struct {
+	host *auth.Host
+}
+
+func (hostAuth) Name() string {
+	return "http-host-credentials"
+}
+
+// String does not show the secrets, as go-git may print it.
+func (a hostAuth) String() string {
+	return a.Name()
+}
+
+func (a hostAuth) SetAuth(req *http.Request) {
+	if value := a.host.GitAuthorization(); value != "" {
+		req.Header.Set("Authorization", value)
+	}
+	for name, value := range a.host.Headers {
+		req.Header.Set(name, value)
+	}
+}
+
+func (b builtinBackend) Fetch(repoSpec *RepoSpec, dir filesys.ConfirmedDir) (string, error) {
+	commit, err := b.fetchRepo(repoSpec, dir)
+	return commit, redact.Error(err)
+}
+
+func (b builtinBackend) fetchRepo(repoSpec *RepoSpec, dir filesys.ConfirmedDir) (string, error) {
+	ra, err := b.remoteAuth(repoSpec.CloneSpec())
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), repoSpec.Timeout)
+	defer cancel()
+
+	repo, err := gogit.PlainInit(dir.String(), false)
+	if err != nil {
+		return "", err
+	}
+	// git relative submodule need origin, see https://github.com/kubernetes-sigs/kustomize/issues/5131
+	remote, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{repoSpec.CloneSpec()}})
+	if err != nil {
+		return "", err
+	}
+	refs, err := remote.ListContext(ctx, ra.listOptions())
+	if err != nil {
+		return "", fmt.Errorf("failed to list refs of %s: %w", repoSpec.CloneSpec(), err)
+	}
+
+	// The in process server of local repositories does not
+	// support shallow fetches, they are fetched entirely.
+	depth := 1
+	if strings.HasPrefix(repoSpec.Host, fileScheme) {
+		depth = 0
+	}
+	var hash plumbing.Hash
+	if ref := findRef(refs, repoSpec.Ref); ref != nil {
+		hash = ref.Hash()
+		err = fetch(ctx, remote, config.RefSpec(ref.Name()+":"+ref.Name()), depth, ra)
+	} else if plumbing.IsHash(repoSpec.Ref) {
+		hash = plumbing.NewHash(repoSpec.Ref)
+		err = fetch(ctx, remote, config.RefSpec(repoSpec.Ref+":"+string(fetchedRef)), depth, ra)
+		if err != nil {
+			// The server does not allow to fetch a commit by hash,
+			// fetch every branch and tag to find it.
+			err = fetch(ctx, remote, "+refs/*:refs/*", 0, ra)
+		}
+	} else {
+		return "", fmt.Errorf("couldn't find remote ref %s in %s", repoSpec.Ref, repoSpec.CloneSpec())
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", repoSpec.RefURL(), err)
+	}
+
+	// Annotated tags point to a tag object, peel it.
+	if tag, err := repo.TagObject(hash); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return "", err
+		}
+		hash = commit.Hash
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	checkout := &gogit.CheckoutOptions{Hash: hash, Force: true}
+	// go-git does not fetch partially, the checkout is still
+	// limited to the directory of the path.
+	if dir := sparseDirAt(repo, hash, repoSpec.sparsePath()); dir != "" {
+		checkout.SparseCheckoutDirectories = []string{dir}
+		repoSpec.SparseDir = dir
+	}
+	if err := worktree.Checkout(checkout); err != nil {
+		return "", fmt.Errorf("failed to checkout %s: %w", hash, err)
+	}
+	if repoSpec.Submodules {
+		if repoSpec.SparseDir != "" {
+			// go-git reads the submodules from the worktree.
+			if err := checkoutFile(repo, hash, dir, gitmodulesFile); err != nil {
+				return "", err
+			}
+		}
+		submodules, err := worktree.Submodules()
+		if err != nil {
+			return "", err
+		}
+		for _, submodule := range submodules {
+			// Only the submodules in the checkout.
+			if !coversPath(repoSpec.SparseDir, submodule.Config().Path) {
+				continue
+			}
+			// go-git only takes the authentication of submodules, not
+			// their TLS settings. Relative URLs are on the same host.
+			submoduleAuth := ra
+			if submoduleURL := submodule.Config().URL; !strings.HasPrefix(submoduleURL, ".") {
+				if submoduleAuth, err = b.remoteAuth(submoduleURL); err != nil {
+					return "", err
+				}
+			}
+			err = submodule.UpdateContext(ctx, &gogit.SubmoduleUpdateOptions{
+				Init:              true,
+				RecurseSubmodules: gogit.DefaultSubmoduleRecursionDepth,
+				Auth:              submoduleAuth.auth,
+			})
+			if err != nil {
+				return "", fmt.Errorf("failed to update submodule %s: %w", submodule.Config().Path, err)
+			}
+		}
+	}
+	return hash.String(), nil
+}
+
+func (b builtinBackend) ResolveRef(repoSpec *RepoSpec) (string, error) {
+	commit, err := b.resolveRef(repoSpec)
+	return commit, redact.Error(err)
+}
+
+func (b builtinBackend) resolveRef(repoSpec *RepoSpec) (string, error) {
+	if commitPattern.MatchString(repoSpec.Ref) {
+		return repoSpec.Ref, nil
+	}
+	ra, err := b.remoteAuth(repoSpec.CloneSpec())
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), repoSpec.Timeout)
+	defer cancel()
+
+	remote := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{repoSpec.CloneSpec()}})
+	options := ra.listOptions()
+	options.PeelingOption = gogit.AppendPeeled
+	refs, err := remote.ListContext(ctx, options)
+	if err != nil {
+		return "", fmt.Errorf("failed to list refs of %s: %w", repoSpec.CloneSpec(), err)
+	}
+	ref := findRef(refs, repoSpec.Ref)
+	if ref == nil {
+		return "", nil
+	}
+	// Annotated tags are listed twice, the peeled ref holds the commit.
+	if peeled := findRefName(refs, ref.Name()+"^{}"); peeled != nil {
+		return peeled.Hash().String(), nil
+	}
+	return ref.Hash().String(), nil
+}
+
+// sparseDirAt returns the directory of the path at the commit,
+// or an empty directory if the whole commit is checked out.
+func sparseDirAt(repo *gogit.Repository, hash plumbing.Hash, p string) string {
+	if p == "" {
+		return ""
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return ""
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return ""
+	}
+	entry, err := tree.FindEntry(p)
+	if err != nil {
+		// Missing path, reported by the loader.
+		return ""
+	}
+	return sparseDir(p, !entry.Mode.IsFile())
+}
+
+// checkoutFile writes a file of the commit in dir,
+// if it exists, e.g. out of a sparse checkout.
+func checkoutFile(repo *gogit.Repository, hash plumbing.Hash, dir filesys.ConfirmedDir, name string) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	file, err := commit.File(name)
+	if err == object.ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dir.Join(name), []byte(content), 0o644)
+}
+
+func fetch(ctx context.Context, remote *gogit.Remote, refSpec config.RefSpec, depth int, ra remoteAuth) error {
+	err := remote.FetchContext(ctx, &gogit.FetchOptions{
+		RefSpecs:   []config.RefSpec{refSpec},
+		Depth:      depth,
+		Tags:       gogit.NoTags,
+		Auth:       ra.auth,
+		CABundle:   ra.caBundle,
+		ClientCert: ra.clientCert,
+		ClientKey:  ra.clientKey,
+	})
+	if err == gogit.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// findRef returns the remote ref a name refers to, following the git rules:
+// an empty name is HEAD, otherwise a full ref name, a tag or a branch name.
+func findRef(refs []*plumbing.Reference, name string) *plumbing.Reference {
+	if name == "" {
+		name = string(plumbing.HEAD)
+	}
+	for _, candidate := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name} {
+		ref := findRefName(refs, plumbing.ReferenceName(candidate))
+		if ref == nil {
+			continue
+		}
+		if ref.Type() == plumbing.SymbolicReference {
+			return findRefName(refs, ref.Target())
+		}
+		return ref
+	}
+	return nil
+}
+
+func findRefName(refs []*plumbing.Reference, name plumbing.ReferenceName) *plumbing.Reference {
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return ref
+		}
+	}
+	return nil
+}
+
+// localLoader loads the local repositories served by the file transport,
+// bare or not.
+type localLoader struct{}
+
+func (localLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	gitDir := ep.Path
+	if _, err := os.Stat(filepath.Join(gitDir, "config")); err != nil {
+		gitDir = filepath.Join(ep.Path, ".git")
+		info, err := os.Stat(gitDir)
+		if err != nil {
+			return nil, transport.ErrRepositoryNotFound
+		}
+		if !info.IsDir() {
+			// A .git file points to the git directory, e.g. in submodules.
+			data, err := os.ReadFile(gitDir)
+			if err != nil {
+				return nil, err
+			}
+			target, found := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+			if !found {
+				return nil, transport.ErrRepositoryNotFound
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(ep.Path, target)
+			}
+			gitDir = target
+		}
+	}
+	return filesystem.NewStorage(osfs.New(gitDir), cache.NewObjectLRUDefault()), nil
+}
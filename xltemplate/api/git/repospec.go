@@ -52,6 +52,21 @@ type RepoSpec struct {
 
 	// Timeout is the maximum duration allowed for execing git commands.
 	Timeout time.Duration
+
+	// Commit is the commit checked out in Dir, when known by the cloner.
+	Commit string
+
+	// SparseDir, if set, is the only directory of the repository
+	// checked out in Dir, the one of KustRootPath.
+	SparseDir string
+
+	// Persistent indicates the clone outlives the loader using it,
+	// e.g. because it is stored in a cache, so it is not cleaned.
+	Persistent bool
+
+	// Cleanup, if set by the cloner, is called instead of
+	// removing Dir once the loader is done with the clone.
+	Cleanup func() error
 }
 
 // CloneSpec returns a string suitable for "git clone {spec}".
@@ -59,6 +74,15 @@ func (x *RepoSpec) CloneSpec() string {
 	return x.Host + x.RepoPath
 }
 
+// RefURL returns the repository URL with the ref, if any,
+// identifying what is cloned whatever the path in the repository.
+func (x *RepoSpec) RefURL() string {
+	if x.Ref == "" {
+		return x.CloneSpec()
+	}
+	return x.CloneSpec() + refQuery + x.Ref
+}
+
 func (x *RepoSpec) CloneDir() filesys.ConfirmedDir {
 	return x.Dir
 }
@@ -72,7 +96,15 @@ func (x *RepoSpec) AbsPath() string {
 }
 
 func (x *RepoSpec) Cleaner(fSys filesys.FileSystem) func() error {
-	return func() error { return fSys.RemoveAll(x.Dir.String()) }
+	return func() error {
+		if x.Cleanup != nil {
+			return x.Cleanup()
+		}
+		if x.Persistent {
+			return nil
+		}
+		return fSys.RemoveAll(x.Dir.String())
+	}
 }
 
 const (
@@ -0,0 +1,64 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+
+	"do3b/xltemplate/api/retry"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// WithRetries returns a backend retrying the operations of next
+// failing with a transient error, e.g. a connection reset or a
+// server error, according to the policy.
+func WithRetries(next Backend, policy retry.Policy) Backend {
+	return retryBackend{next: next, policy: policy}
+}
+
+type retryBackend struct {
+	next   Backend
+	policy retry.Policy
+}
+
+func (b retryBackend) Fetch(repoSpec *RepoSpec, dir filesys.ConfirmedDir) (string, error) {
+	var commit string
+	attempt := 0
+	err := b.policy.Do(func() error {
+		attempt++
+		if attempt > 1 {
+			// Start again from an empty directory.
+			if err := emptyDir(dir.String()); err != nil {
+				return err
+			}
+		}
+		var err error
+		commit, err = b.next.Fetch(repoSpec, dir)
+		return err
+	})
+	return commit, err
+}
+
+func (b retryBackend) ResolveRef(repoSpec *RepoSpec) (string, error) {
+	var commit string
+	err := b.policy.Do(func() error {
+		var err error
+		commit, err = b.next.ResolveRef(repoSpec)
+		return err
+	})
+	return commit, err
+}
+
+// emptyDir removes the content of dir.
+func emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
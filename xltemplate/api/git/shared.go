@@ -0,0 +1,147 @@
+package git
+
+import (
+	"sync"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// SharedClones shares a single clone between the references to the same
+// repository and ref, e.g. a source and patterns from the same repository,
+// for the duration of a build. A clone limited to a directory is only
+// shared with the references to paths in it. Clones are reference counted: each loader
+// holds a reference until its cleanup, and the set holds one until Close,
+// so a clone is removed once, when both are done with it.
+type SharedClones struct {
+	next   Cloner
+	mu     sync.Mutex
+	clones map[string][]*sharedClone
+	closed bool
+}
+
+type sharedClone struct {
+	// done is closed once the clone is complete.
+	done chan struct{}
+	err  error
+	spec RepoSpec
+	refs int
+	// path is the path the clone was requested for.
+	path string
+}
+
+// NewSharedClones returns an empty set of clones,
+// cloned with next.
+func NewSharedClones(next Cloner) *SharedClones {
+	return &SharedClones{next: next, clones: map[string][]*sharedClone{}}
+}
+
+// Cloner returns a cloner cloning each repository and ref once, and
+// pointing the other references to the same clone. It can be called
+// concurrently.
+func (s *SharedClones) Cloner() Cloner {
+	return func(repoSpec *RepoSpec) error {
+		key := repoSpec.RefURL()
+		if !repoSpec.Submodules {
+			key += " (no submodules)"
+		}
+
+		s.mu.Lock()
+		c := s.find(key, repoSpec.sparsePath())
+		ok := c != nil
+		if !ok {
+			// The set holds a reference until Close.
+			c = &sharedClone{done: make(chan struct{}), spec: *repoSpec, refs: 1, path: repoSpec.sparsePath()}
+			s.clones[key] = append(s.clones[key], c)
+		}
+		s.mu.Unlock()
+
+		if ok {
+			<-c.done
+		} else {
+			c.err = s.next(&c.spec)
+			if c.err != nil {
+				_ = c.spec.Cleaner(filesys.MakeFsOnDisk())()
+			}
+			close(c.done)
+		}
+		if c.err != nil {
+			return c.err
+		}
+
+		s.mu.Lock()
+		c.refs++
+		s.mu.Unlock()
+		repoSpec.Dir = c.spec.Dir
+		repoSpec.Commit = c.spec.Commit
+		repoSpec.SparseDir = c.spec.SparseDir
+		repoSpec.Persistent = c.spec.Persistent
+		var once sync.Once
+		repoSpec.Cleanup = func() error {
+			var err error
+			once.Do(func() { err = s.release(c) })
+			return err
+		}
+		return nil
+	}
+}
+
+// Close releases the references held by the set, removing
+// the clones which are not used by a loader anymore.
+func (s *SharedClones) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var clones []*sharedClone
+	for _, list := range s.clones {
+		clones = append(clones, list...)
+	}
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, c := range clones {
+		<-c.done
+		if c.err != nil {
+			continue
+		}
+		if err := s.release(c); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// find returns a clone holding the path, complete or in progress,
+// or nil. Failed clones are returned too, so that their error is shared.
+func (s *SharedClones) find(key string, path string) *sharedClone {
+	for _, c := range s.clones[key] {
+		select {
+		case <-c.done:
+			if c.err != nil || coversPath(c.spec.SparseDir, path) {
+				return c
+			}
+		default:
+			// The directory is not known yet, but
+			// holds at least the requested path.
+			if coversPath(c.path, path) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// release drops a reference to a clone, removing it
+// if it was the last one.
+func (s *SharedClones) release(c *sharedClone) error {
+	s.mu.Lock()
+	c.refs--
+	last := c.refs == 0
+	s.mu.Unlock()
+	if !last {
+		return nil
+	}
+	return c.spec.Cleaner(filesys.MakeFsOnDisk())()
+}
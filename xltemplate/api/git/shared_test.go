@@ -0,0 +1,166 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// fakeCloner counts the clones, made in new directories of dir.
+func fakeCloner(t *testing.T, clones *atomic.Int32) Cloner {
+	dir := t.TempDir()
+	return func(repoSpec *RepoSpec) error {
+		clones.Add(1)
+		cloneDir, err := os.MkdirTemp(dir, "clone-")
+		if err != nil {
+			return err
+		}
+		repoSpec.Dir = filesys.ConfirmedDir(cloneDir)
+		return nil
+	}
+}
+
+func newRepoSpec(t *testing.T, url string) *RepoSpec {
+	t.Helper()
+	repoSpec, err := NewRepoSpecFromURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repoSpec
+}
+
+// acquire clones the url n times concurrently, returning the clones.
+func acquire(t *testing.T, cloner Cloner, url string, n int) []*RepoSpec {
+	t.Helper()
+	repoSpecs := make([]*RepoSpec, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range repoSpecs {
+		repoSpecs[i] = newRepoSpec(t, url)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = cloner(repoSpecs[i])
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	return repoSpecs
+}
+
+// release cleans up the clones concurrently, twice each.
+func release(t *testing.T, repoSpecs []*RepoSpec) {
+	t.Helper()
+	errs := make([]error, 2*len(repoSpecs))
+	var wg sync.WaitGroup
+	for i, repoSpec := range repoSpecs {
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[2*i+j] = repoSpec.Cleaner(filesys.MakeFsOnDisk())()
+			}()
+		}
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(dir filesys.ConfirmedDir) bool {
+	_, err := os.Stat(dir.String())
+	return err == nil
+}
+
+func TestSharedClones(t *testing.T) {
+	for _, closeFirst := range []bool{false, true} {
+		name := "released before close"
+		if closeFirst {
+			name = "closed before release"
+		}
+		t.Run(name, func(t *testing.T) {
+			var clones atomic.Int32
+			shared := NewSharedClones(fakeCloner(t, &clones))
+			cloner := shared.Cloner()
+
+			main := acquire(t, cloner, "https://github.com/org/repo?ref=main", 20)
+			other := acquire(t, cloner, "https://github.com/org/repo?ref=other", 5)
+			if n := clones.Load(); n != 2 {
+				t.Fatalf("got %d clones, want 2", n)
+			}
+			for _, repoSpec := range main {
+				if repoSpec.Dir != main[0].Dir {
+					t.Fatalf("got clone %s, want %s", repoSpec.Dir, main[0].Dir)
+				}
+			}
+			if main[0].Dir == other[0].Dir {
+				t.Fatal("refs share a clone")
+			}
+
+			// The loaders of a first target are cleaned up.
+			release(t, main[:10])
+			if !exists(main[0].Dir) {
+				t.Fatal("clone removed while in use")
+			}
+			if closeFirst {
+				if err := shared.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if !exists(main[0].Dir) || !exists(other[0].Dir) {
+					t.Fatal("clone removed on close while in use")
+				}
+			}
+			release(t, main[10:])
+			release(t, other)
+			if closeFirst == exists(main[0].Dir) {
+				t.Errorf("clone exists: %t, want %t", exists(main[0].Dir), !closeFirst)
+			}
+			if err := shared.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if exists(main[0].Dir) || exists(other[0].Dir) {
+				t.Error("clones not removed")
+			}
+		})
+	}
+}
+
+func TestSharedClonesError(t *testing.T) {
+	var clones atomic.Int32
+	cloneErr := errors.New("clone failed")
+	shared := NewSharedClones(func(repoSpec *RepoSpec) error {
+		clones.Add(1)
+		return cloneErr
+	})
+	cloner := shared.Cloner()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		repoSpec := newRepoSpec(t, "https://github.com/org/repo?ref=main")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = cloner(repoSpec)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if !errors.Is(err, cloneErr) {
+			t.Errorf("got %v, want %v", err, cloneErr)
+		}
+	}
+	if n := clones.Load(); n != 1 {
+		t.Errorf("got %d clones, want 1", n)
+	}
+	if err := shared.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
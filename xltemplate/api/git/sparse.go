@@ -0,0 +1,55 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sparsePath returns the path in the repository the checkout can be
+// limited to, or an empty path when the whole repository is needed.
+func (x *RepoSpec) sparsePath() string {
+	p := strings.Trim(path.Clean("/"+filepath.ToSlash(x.KustRootPath)), "/")
+	if p == "" || p == "." {
+		return ""
+	}
+	return p
+}
+
+// sparseKey identifies the sparse path in cache entry names.
+func (x *RepoSpec) sparseKey() string {
+	sum := sha256.Sum256([]byte(x.sparsePath()))
+	return hex.EncodeToString(sum[:6])
+}
+
+// sparseDir returns the directory a checkout is limited to for the
+// path: the path itself for a directory, or the directory of a file.
+func sparseDir(p string, isDir bool) string {
+	if isDir {
+		return p
+	}
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// sparseDirOf returns the directory a checkout in dir is limited
+// to, from the files which were checked out.
+func sparseDirOf(dir string, repoSpec *RepoSpec) string {
+	p := repoSpec.sparsePath()
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p)))
+	if err != nil {
+		return p
+	}
+	return sparseDir(p, info.IsDir())
+}
+
+// coversPath tells whether a checkout limited to dir
+// holds p, a path in the repository.
+func coversPath(dir string, p string) bool {
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
@@ -3,9 +3,33 @@
 
 package loader
 
-import "sigs.k8s.io/kustomize/kyaml/errors"
+import (
+	"fmt"
+	"net/http"
+
+	"sigs.k8s.io/kustomize/kyaml/errors"
+)
 
 var (
 	ErrHTTP     = errors.Errorf("HTTP Error")
 	ErrRtNotDir = errors.Errorf("must build at directory")
 )
+
+// StatusError is the error of an unsuccessful HTTP response.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: status code %d (%s)", ErrHTTP, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *StatusError) Unwrap() error {
+	return ErrHTTP
+}
+
+// Temporary tells whether the request may succeed later,
+// on server errors and 429 Too Many Requests.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
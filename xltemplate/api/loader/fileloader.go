@@ -13,6 +13,7 @@ import (
 	"strings"
 
 	"do3b/xltemplate/api/git"
+	"do3b/xltemplate/api/retry"
 
 	"sigs.k8s.io/kustomize/kyaml/errors"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
@@ -99,6 +100,10 @@ type FileLoader struct {
 	// Used to load from HTTP
 	http *http.Client
 
+	// Used to retry the HTTP requests failing with
+	// a transient error.
+	retry retry.Policy
+
 	// Used to clone repositories.
 	cloner git.Cloner
 
@@ -163,8 +168,14 @@ func (fl *FileLoader) New(path string) (*FileLoader, error) {
 		if err = fl.errIfRepoCycle(repoSpec); err != nil {
 			return nil, err
 		}
-		return newLoaderAtGitClone(
+		child, err := newLoaderAtGitClone(
 			repoSpec, fl.fSys, fl, fl.cloner)
+		if err != nil {
+			return nil, err
+		}
+		child.http = fl.http
+		child.retry = fl.retry
+		return child, nil
 	}
 
 	if filepath.IsAbs(path) {
@@ -172,7 +183,7 @@ func (fl *FileLoader) New(path string) (*FileLoader, error) {
 	}
 	root, err := filesys.ConfirmDir(fl.fSys, fl.root.Join(path))
 	if err != nil {
-		return nil, errors.WrapPrefixf(err, ErrRtNotDir.Error()) //nolint:govet
+		return nil, errors.WrapPrefixf(err, "%s", ErrRtNotDir.Error())
 	}
 	if err = fl.errIfGitContainmentViolation(root); err != nil {
 		return nil, err
@@ -180,8 +191,11 @@ func (fl *FileLoader) New(path string) (*FileLoader, error) {
 	if err = fl.errIfArgEqualOrHigher(root); err != nil {
 		return nil, err
 	}
-	return newLoaderAtConfirmedDir(
-		fl.loadRestrictor, root, fl.fSys, fl, fl.cloner, ""), nil
+	child := newLoaderAtConfirmedDir(
+		fl.loadRestrictor, root, fl.fSys, fl, fl.cloner, "")
+	child.http = fl.http
+	child.retry = fl.retry
+	return child, nil
 }
 
 // newLoaderAtGitClone returns a new Loader pinned to a temporary
@@ -304,6 +318,16 @@ func (fl *FileLoader) Load(path string) ([]byte, error) {
 }
 
 func (fl *FileLoader) httpClientGetContent(path string) ([]byte, error) {
+	var content []byte
+	err := fl.retry.Do(func() error {
+		var err error
+		content, err = fl.httpGet(path)
+		return err
+	})
+	return content, err
+}
+
+func (fl *FileLoader) httpGet(path string) ([]byte, error) {
 	var hc *http.Client
 	if fl.http != nil {
 		hc = fl.http
@@ -321,7 +345,7 @@ func (fl *FileLoader) httpClientGetContent(path string) ([]byte, error) {
 		if err == nil {
 			return nil, errors.Errorf("URL is a git repository")
 		}
-		return nil, fmt.Errorf("%w: status code %d (%s)", ErrHTTP, resp.StatusCode, http.StatusText(resp.StatusCode))
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 	content, err := io.ReadAll(resp.Body)
 	return content, errors.Wrap(err)
@@ -6,6 +6,8 @@ package loader
 
 import (
 	"do3b/xltemplate/api/git"
+	"do3b/xltemplate/api/retry"
+	"net/http"
 	"path/filepath"
 
 	"sigs.k8s.io/kustomize/kyaml/errors"
@@ -21,26 +23,70 @@ import (
 func NewLoader(
 	lr LoadRestrictorFunc,
 	target string, fSys filesys.FileSystem) (*FileLoader, error) {
+	return NewLoaderWithOptions(lr, target, fSys, RemoteOptions{})
+}
+
+// RemoteOptions tell how loaders fetch remote targets. They are
+// inherited by the child loaders.
+type RemoteOptions struct {
+	// Cloner clones the git repositories, git.ClonerUsingGitExec if nil.
+	Cloner git.Cloner
+	// HTTPClient fetches the HTTP files, a default client if nil.
+	HTTPClient *http.Client
+	// Retry retries the HTTP requests failing with a transient error.
+	// Cloners retry on their own.
+	Retry retry.Policy
+}
+
+// NewLoaderWithOptions is NewLoader fetching remote targets
+// with the given options.
+func NewLoaderWithOptions(
+	lr LoadRestrictorFunc,
+	target string, fSys filesys.FileSystem, remote RemoteOptions) (*FileLoader, error) {
+	fl, err := newLoader(lr, target, fSys, remote)
+	if err != nil {
+		return nil, err
+	}
+	fl.http = remote.HTTPClient
+	fl.retry = remote.Retry
+	return fl, nil
+}
+
+func newLoader(
+	lr LoadRestrictorFunc,
+	target string, fSys filesys.FileSystem, remote RemoteOptions) (*FileLoader, error) {
+	cloner := remote.Cloner
+	if cloner == nil {
+		cloner = git.ClonerUsingGitExec
+	}
 	repoSpec, err := git.NewRepoSpecFromURL(target)
 	if err == nil {
 		// The target qualifies as a remote git target.
 		return newLoaderAtGitClone(
-			repoSpec, fSys, nil, git.ClonerUsingGitExec)
+			repoSpec, fSys, nil, cloner)
+	}
+	if IsRemoteFile(target) {
+		// Remote files are fetched by Load, the loader is
+		// rooted in the current directory.
+		root, err := filesys.ConfirmDir(fSys, filesys.SelfDir)
+		if err != nil {
+			return nil, errors.WrapPrefixf(err, "%s", ErrRtNotDir.Error())
+		}
+		return newLoaderAtConfirmedDir(
+			RestrictionRootOnly, root, fSys, nil, cloner, target), nil
 	}
 	var root filesys.ConfirmedDir
 	cleanedTarget := target
 	if !fSys.IsDir(target) {
-		if !IsRemoteFile(target) {
-			cleanedTarget = filepath.Base(target)
-		}
+		cleanedTarget = filepath.Base(target)
 		root, _, err = fSys.CleanedAbs(target)
 	} else {
 		root, err = filesys.ConfirmDir(fSys, target)
 	}
 
 	if err != nil {
-		return nil, errors.WrapPrefixf(err, ErrRtNotDir.Error()) //nolint:govet
+		return nil, errors.WrapPrefixf(err, "%s", ErrRtNotDir.Error())
 	}
 	return newLoaderAtConfirmedDir(
-		lr, root, fSys, nil, git.ClonerUsingGitExec, cleanedTarget), nil
+		lr, root, fSys, nil, cloner, cleanedTarget), nil
 }
@@ -0,0 +1,283 @@
+// Package lock pins the remote references of a build, so
+// that successive builds fetch the same content.
+package lock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"do3b/xltemplate/api/git"
+	"do3b/xltemplate/api/utils"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Extension is the extension of the lock files, written next to their
+// xltemplate file and named after it, e.g. xltemplate.lock.
+const Extension = ".lock"
+
+const hashPrefix = "sha256:"
+
+// content is the content of the lock file.
+type content struct {
+	// Git maps a repository and ref, e.g. https://github.com/org/repo?ref=main,
+	// to the commit it was resolved to.
+	Git map[string]string `yaml:"git,omitempty"`
+	// HTTP maps a URL to the hash of its content.
+	HTTP map[string]string `yaml:"http,omitempty"`
+}
+
+// Lock records the commit each git reference is resolved to and the
+// hash of each HTTP file. Locked git references are cloned at their
+// commit, and locked HTTP files must still have the same hash.
+type Lock struct {
+	path    string
+	exists  bool
+	content content
+	changed bool
+	// used are the git and HTTP entries used since the lock was loaded.
+	used content
+	mu   sync.Mutex
+
+	// Frozen makes unlocked references an error, instead of
+	// locking them.
+	Frozen bool
+}
+
+// PathOf returns the path of the lock file of an xltemplate file, so
+// that xltemplate files of the same directory have their own lock.
+func PathOf(xltemplateFile string) string {
+	return strings.TrimSuffix(xltemplateFile, filepath.Ext(xltemplateFile)) + Extension
+}
+
+// Load reads the lock file at path. A missing file
+// yields an empty lock.
+func Load(path string) (*Lock, error) {
+	l := &Lock{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &l.content); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lock file %s: %w", path, err)
+	}
+	l.exists = true
+	return l, nil
+}
+
+// Path returns the path of the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Exists tells whether the lock file exists.
+func (l *Lock) Exists() bool {
+	return l.exists
+}
+
+// Save writes the lock file if references were locked since it was loaded.
+func (l *Lock) Save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.changed {
+		return nil
+	}
+	data, err := yaml.Marshal(l.content)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	l.changed = false
+	l.exists = true
+	return nil
+}
+
+// Forget removes the entries of the given reference, a git URL, with
+// or without ref, or an HTTP URL, so that they are locked again. An
+// empty reference removes every entry. It returns the number of
+// removed entries.
+func (l *Lock) Forget(reference string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	removed := 0
+	if reference == "" {
+		removed = len(l.content.Git) + len(l.content.HTTP)
+		l.content = content{}
+		// The lock is written even if empty, as there may be no
+		// remote reference to lock.
+		l.changed = true
+	} else {
+		if _, ok := l.content.HTTP[reference]; ok {
+			delete(l.content.HTTP, reference)
+			removed++
+		}
+		if repoSpec, err := git.NewRepoSpecFromURL(reference); err == nil {
+			for key := range l.content.Git {
+				repo, ref, _ := strings.Cut(key, "?ref=")
+				if repo == repoSpec.CloneSpec() && (repoSpec.Ref == "" || ref == repoSpec.Ref) {
+					delete(l.content.Git, key)
+					removed++
+				}
+			}
+		}
+	}
+	if removed > 0 {
+		l.changed = true
+	}
+	return removed
+}
+
+// Cloner returns a cloner fetching the locked commit of the repositories
+// with next. Unlocked repositories are cloned at their ref, then the commit
+// they were checked out at is locked.
+func (l *Lock) Cloner(next git.Cloner) git.Cloner {
+	return func(repoSpec *git.RepoSpec) error {
+		key := repoSpec.RefURL()
+		l.mu.Lock()
+		commit, locked := l.content.Git[key]
+		l.mu.Unlock()
+
+		if locked {
+			l.mu.Lock()
+			l.used.Git = used(l.used.Git, key, commit)
+			l.mu.Unlock()
+			repoSpec.Ref = commit
+			return next(repoSpec)
+		}
+		if l.Frozen {
+			return l.frozenError(key)
+		}
+		if err := next(repoSpec); err != nil {
+			return err
+		}
+		commit = repoSpec.Commit
+		if commit == "" {
+			var err error
+			if commit, err = git.HeadCommit(repoSpec); err != nil {
+				return err
+			}
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.content.Git == nil {
+			l.content.Git = map[string]string{}
+		}
+		l.content.Git[key] = commit
+		l.changed = true
+		l.used.Git = used(l.used.Git, key, commit)
+		return nil
+	}
+}
+
+// HTTPClient returns a client checking the hash of the HTTP
+// files against the lock, or locking it.
+func (l *Lock) HTTPClient(next *http.Client) *http.Client {
+	return utils.BodyClient(next, func(req *http.Request, body []byte) error {
+		return l.checkHTTP(req.URL.String(), body)
+	})
+}
+
+// checkHTTP compares the hash of the content fetched from
+// url to the locked one, or locks it.
+func (l *Lock) checkHTTP(url string, body []byte) error {
+	sum := sha256.Sum256(body)
+	hash := hashPrefix + hex.EncodeToString(sum[:])
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	locked, ok := l.content.HTTP[url]
+	if ok {
+		if locked != hash {
+			return fmt.Errorf("content of %s does not match %s (got %s, locked %s), run 'xltemplate lock update %s' to accept it",
+				url, l.path, hash, locked, url)
+		}
+		l.used.HTTP = used(l.used.HTTP, url, hash)
+		return nil
+	}
+	if l.Frozen {
+		return l.frozenError(url)
+	}
+	if l.content.HTTP == nil {
+		l.content.HTTP = map[string]string{}
+	}
+	l.content.HTTP[url] = hash
+	l.changed = true
+	l.used.HTTP = used(l.used.HTTP, url, hash)
+	return nil
+}
+
+// used records an entry in the used entries m, created if nil.
+func used(m map[string]string, key string, value string) map[string]string {
+	if m == nil {
+		m = map[string]string{}
+	}
+	m[key] = value
+	return m
+}
+
+// Unused returns the locked references which were not used since
+// the lock was loaded, sorted, e.g. after a build to find the
+// references the xltemplate file no longer has.
+func (l *Lock) Unused() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var unused []string
+	for key := range l.content.Git {
+		if _, ok := l.used.Git[key]; !ok {
+			unused = append(unused, key)
+		}
+	}
+	for url := range l.content.HTTP {
+		if _, ok := l.used.HTTP[url]; !ok {
+			unused = append(unused, url)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+// ForgetUnused removes the entries which were not used since the lock
+// was loaded. It returns the number of removed entries.
+func (l *Lock) ForgetUnused() int {
+	unused := l.Unused()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range unused {
+		delete(l.content.Git, key)
+		delete(l.content.HTTP, key)
+	}
+	if len(unused) > 0 {
+		l.changed = true
+	}
+	return len(unused)
+}
+
+// UnusedError returns an error listing the unused entries, as
+// --frozen fails on a lock holding references no longer used.
+func (l *Lock) UnusedError() error {
+	unused := l.Unused()
+	if len(unused) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s locks references no longer used: %s, run 'xltemplate lock update' to remove them",
+		l.path, strings.Join(unused, ", "))
+}
+
+func (l *Lock) frozenError(key string) error {
+	if !l.exists {
+		return fmt.Errorf("lock file %s is missing, run 'xltemplate lock update' to create it", l.path)
+	}
+	return fmt.Errorf("%s is not locked in %s, run 'xltemplate lock update' to lock it", key, l.path)
+}
@@ -0,0 +1,129 @@
+// Package redact hides secrets, e.g. tokens, in error messages and logs.
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the secrets.
+const Mask = "***"
+
+var (
+	mu      sync.RWMutex
+	secrets = map[string]bool{}
+
+	// userinfoPattern matches the password of the URLs.
+	userinfoPattern = regexp.MustCompile(`(://[^/:@\s]*):[^/@\s]+@`)
+)
+
+// Add records secrets to hide from now on. Empty strings are ignored.
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, value := range values {
+		if value != "" {
+			secrets[value] = true
+		}
+	}
+}
+
+// String hides the recorded secrets and the passwords of URLs in s.
+func String(s string) string {
+	s = userinfoPattern.ReplaceAllString(s, "$1:"+Mask+"@")
+	mu.RLock()
+	defer mu.RUnlock()
+	for secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return s
+}
+
+// Error returns err with its secrets hidden, or
+// err itself if its message holds no secret.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := String(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{message: message, err: err}
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Handler is a log handler hiding the secrets
+// of the messages and attributes.
+type Handler struct {
+	slog.Handler
+}
+
+// NewHandler returns a handler hiding the secrets before
+// passing the records to next.
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{Handler: next}
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, String(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(attrOf(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = attrOf(attr)
+	}
+	return &Handler{Handler: h.Handler.WithAttrs(redacted)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
+
+// attrOf returns the attribute with its secrets hidden. Values are
+// turned into strings as by the text handlers only if they hold one.
+func attrOf(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, String(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = attrOf(member)
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.Any(attr.Key, Error(err))
+		}
+		text := fmt.Sprintf("%+v", value.Any())
+		if redacted := String(text); redacted != text {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+	return attr
+}
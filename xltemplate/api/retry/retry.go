@@ -0,0 +1,105 @@
+// Package retry retries the remote operations failing with
+// transient errors, with exponential backoff.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"syscall"
+	"time"
+
+	"do3b/xltemplate/api/utils"
+)
+
+// Policy tells how often and when an operation is retried.
+// The zero policy does not retry.
+type Policy struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled
+	// before each following one, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultPolicy makes 3 attempts, 1 then 2 seconds apart.
+var DefaultPolicy = Policy{Attempts: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second}
+
+var (
+	// permanentPattern matches the messages of git and go-git
+	// errors which retrying can't fix, e.g. a 404 or an
+	// authentication failure.
+	permanentPattern = regexp.MustCompile(`(?i)(authentication (failed|required)|authorization failed|permission denied|not found|could not read username|couldn't find remote ref|returned error: 4\d\d|http 4\d\d|status code: 4\d\d)`)
+	// transientPattern matches the messages of git and go-git
+	// errors on network failures and server errors.
+	transientPattern = regexp.MustCompile(`(?i)(connection reset|connection timed out|operation timed out|early eof|unexpected disconnect|remote end hung up|rpc failed; (curl|http 5\d\d)|returned error: (5\d\d|429)|status code: (5\d\d|429)|tls handshake timeout)`)
+)
+
+// Do runs fn until it succeeds, fails with an error which is not
+// retryable, or the attempts are exhausted. The error of the last
+// attempt tells the number of attempts.
+func (p Policy) Do(fn func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !Retryable(err) {
+			return err
+		}
+		if attempt >= p.Attempts {
+			if attempt == 1 {
+				return err
+			}
+			return &Error{Attempts: attempt, Err: err}
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// Error is the error of an operation which failed every attempt.
+type Error struct {
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable tells whether an operation failing with err may succeed if
+// retried: on timeouts, connection resets and server errors.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if utils.IsErrTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// e.g. the HTTP status errors of the loader, or syscall errors
+	// such as ECONNRESET and ETIMEDOUT.
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// git reports the errors in its output.
+	message := err.Error()
+	return !permanentPattern.MatchString(message) && transientPattern.MatchString(message)
+}
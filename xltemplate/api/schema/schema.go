@@ -0,0 +1,174 @@
+// Package schema validates variables against a JSON Schema.
+package schema
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"do3b/xltemplate/api/loader"
+	"do3b/xltemplate/api/values"
+
+	"github.com/mitchellh/copystructure"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+var printer = message.NewPrinter(language.English)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	name   string
+	schema *jsonschema.Schema
+}
+
+// Load loads and compiles the JSON Schema at target, either a local path
+// or a git or HTTP URL, in JSON or YAML. Relative $refs are loaded through
+// the same loader, so they are restricted to the repository for git URLs.
+func Load(target string, fSys filesys.FileSystem, remote loader.RemoteOptions) (*Schema, error) {
+	schemaLoader, err := loader.NewLoaderWithOptions(loader.RestrictionNone, target, fSys, remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema: %w", err)
+	}
+	// References are all loaded by Compile, so the clone can be removed afterwards.
+	defer schemaLoader.Cleanup()
+
+	location := schemaLoader.FilePath
+	if !loader.IsRemoteFile(location) {
+		location = (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(schemaLoader.Root(), location))}).String()
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.UseLoader(urlLoader{fileLoader: schemaLoader})
+	schema, err := compiler.Compile(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema %s: %w", target, err)
+	}
+	return &Schema{name: target, schema: schema}, nil
+}
+
+// Validate validates the variables against the schema. The origins
+// tell the files which contributed each value, to point to them in
+// the error.
+func (s *Schema) Validate(variables map[string]interface{}, origins values.Origins) error {
+	err := s.schema.Validate(map[string]interface{}(variables))
+	if err == nil {
+		return nil
+	}
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+
+	var lines []string
+	for _, leaf := range leafErrors(validationErr) {
+		line := fmt.Sprintf("  %s: %s", jsonPointer(leaf.InstanceLocation), leaf.ErrorKind.LocalizedString(printer))
+		if origin := origins.Lookup(variables, leaf.InstanceLocation); origin != "" {
+			line += fmt.Sprintf(" (from %s)", origin)
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return fmt.Errorf("variables do not match schema %s:\n%s", s.name, strings.Join(lines, "\n"))
+}
+
+// leafErrors returns the errors without causes, which are the
+// most precise ones.
+func leafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
+	}
+	return leaves
+}
+
+func jsonPointer(path []string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	escaped := make([]string, len(path))
+	for i, element := range path {
+		escaped[i] = strings.ReplaceAll(strings.ReplaceAll(element, "~", "~0"), "/", "~1")
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// urlLoader loads schemas through a FileLoader, so
+// its restrictions apply to referenced schemas.
+type urlLoader struct {
+	fileLoader *loader.FileLoader
+}
+
+func (l urlLoader) Load(location string) (any, error) {
+	path := location
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "file" {
+		path = filepath.FromSlash(u.Path)
+	}
+	data, err := l.fileLoader.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return values.Decode(data, values.FormatFromPath(path))
+}
+
+// Defaults returns the default values declared in a JSON Schema document:
+// the default of the root schema, if it is an object, overridden by the
+// defaults of its properties, recursively. References are not followed.
+func Defaults(document map[string]interface{}) map[string]interface{} {
+	defaults, _ := defaultValue(document).(map[string]interface{})
+	if defaults == nil {
+		defaults = map[string]interface{}{}
+	}
+	return defaults
+}
+
+func defaultValue(document map[string]interface{}) interface{} {
+	// The default is copied, as the defaults of the properties are merged
+	// into it and the caller may change the result.
+	value, err := copystructure.Copy(document["default"])
+	if err != nil {
+		return nil
+	}
+	properties, ok := document["properties"].(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	defaults, ok := value.(map[string]interface{})
+	if !ok {
+		if value != nil {
+			return value
+		}
+		defaults = map[string]interface{}{}
+	}
+	for name, property := range properties {
+		propertySchema, ok := property.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if propertyDefault := defaultValue(propertySchema); propertyDefault != nil {
+			if existing, ok := defaults[name].(map[string]interface{}); ok {
+				if nested, ok := propertyDefault.(map[string]interface{}); ok {
+					_ = values.Merge(existing, nested, values.MergeOptions{})
+					continue
+				}
+			}
+			defaults[name] = propertyDefault
+		}
+	}
+	if len(defaults) == 0 {
+		return nil
+	}
+	return defaults
+}
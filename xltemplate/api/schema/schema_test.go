@@ -0,0 +1,48 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	"do3b/xltemplate/api/values"
+
+	"github.com/mitchellh/copystructure"
+)
+
+func TestDefaults(t *testing.T) {
+	document, err := values.Decode([]byte(`{
+		"default": {"db": {"host": "h"}, "name": "n"},
+		"properties": {
+			"db": {
+				"default": {"user": "u"},
+				"properties": {"port": {"default": "p"}}
+			},
+			"tags": {"default": {"env": "dev"}}
+		}
+	}`), values.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := copystructure.Copy(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := Defaults(document)
+	want := map[string]interface{}{
+		"db":   map[string]interface{}{"host": "h", "user": "u", "port": "p"},
+		"name": "n",
+		"tags": map[string]interface{}{"env": "dev"},
+	}
+	if !reflect.DeepEqual(defaults, want) {
+		t.Errorf("got %v, want %v", defaults, want)
+	}
+
+	// Changing the defaults, e.g. merging the values files, leaves the document.
+	if err := values.Merge(defaults, map[string]interface{}{"tags": map[string]interface{}{"team": "t"}}, values.MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(document, original) {
+		t.Errorf("document changed to %v", document)
+	}
+}
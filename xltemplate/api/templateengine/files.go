@@ -0,0 +1,118 @@
+package templateengine
+
+import (
+	"encoding/base64"
+	"path"
+	"sort"
+	"strings"
+
+	"do3b/xltemplate/api/values"
+)
+
+// FilesKey is the key of the files in the data of the templates,
+// e.g. {{ .Files.Get "ca.pem" }}, or {{ $.Files.Get "ca.pem" }}
+// within range or with.
+const FilesKey = "Files"
+
+// Files are the files of the patterns which are not templates, by name,
+// read on demand. Missing files are read as empty, as in Helm.
+type Files struct {
+	readers map[string]func() ([]byte, error)
+}
+
+// NewFiles returns an empty set of files.
+func NewFiles() *Files {
+	return &Files{readers: map[string]func() ([]byte, error){}}
+}
+
+// Add adds a file read by read, replacing the file of the same name.
+func (f *Files) Add(name string, read func() ([]byte, error)) {
+	f.readers[name] = read
+}
+
+// Len returns the number of files, 0 for nil files.
+func (f *Files) Len() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.readers)
+}
+
+// Names returns the names of the files, sorted.
+func (f *Files) Names() []string {
+	names := make([]string, 0, len(f.readers))
+	for name := range f.readers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetBytes returns the content of a file.
+func (f *Files) GetBytes(name string) ([]byte, error) {
+	read, ok := f.readers[name]
+	if !ok {
+		return nil, nil
+	}
+	return read()
+}
+
+// Get returns the content of a file as a string.
+func (f *Files) Get(name string) (string, error) {
+	data, err := f.GetBytes(name)
+	return string(data), err
+}
+
+// Lines returns the lines of a file, e.g. to range over them.
+func (f *Files) Lines(name string) ([]string, error) {
+	content, err := f.Get(name)
+	if err != nil || content == "" {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), nil
+}
+
+// Glob returns the files whose name matches a pattern, as
+// by path.Match, e.g. {{ .Files.Glob "sql/*.sql" }}.
+func (f *Files) Glob(pattern string) (*Files, error) {
+	matches := NewFiles()
+	for name, read := range f.readers {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matches.Add(name, read)
+		}
+	}
+	return matches, nil
+}
+
+// AsConfig returns the files as a YAML map of their base name
+// to their content, e.g. for the data of a ConfigMap.
+func (f *Files) AsConfig() (string, error) {
+	return f.asMap(func(data []byte) string {
+		return string(data)
+	})
+}
+
+// AsSecrets returns the files as a YAML map of their base name
+// to their base64 encoded content, e.g. for the data of a Secret.
+func (f *Files) AsSecrets() (string, error) {
+	return f.asMap(base64.StdEncoding.EncodeToString)
+}
+
+func (f *Files) asMap(value func([]byte) string) (string, error) {
+	if len(f.readers) == 0 {
+		return "", nil
+	}
+	m := map[string]interface{}{}
+	for _, name := range f.Names() {
+		data, err := f.GetBytes(name)
+		if err != nil {
+			return "", err
+		}
+		m[path.Base(name)] = value(data)
+	}
+	return encode(m, values.FormatYAML)
+}
@@ -0,0 +1,118 @@
+package templateengine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/template"
+)
+
+// tplName is the name of the templates parsed by the tpl function.
+const tplName = "<tpl>"
+
+var locationRegexp = regexp.MustCompile(`^template: (.+?):(\d+):(\d+): executing `)
+
+// failure is the error of the fail and required functions.
+type failure struct {
+	message string
+}
+
+func (f *failure) Error() string {
+	return f.message
+}
+
+// FailError is returned by Parse when a template calls fail, or calls
+// required with a missing value. It locates the call.
+type FailError struct {
+	Template string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (e *FailError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Template, e.Line, e.Column, e.Message)
+}
+
+// fail stops the rendering with a message, e.g. {{ fail "unsupported" }}.
+func fail(message string) (string, error) {
+	return "", &failure{message: message}
+}
+
+// required returns the value, or stops the rendering with a message if
+// the value is missing or an empty string, e.g. {{ required "db.host is
+// required" .db.host }}.
+func required(message string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, &failure{message: message}
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return nil, &failure{message: message}
+	}
+	return value, nil
+}
+
+// tplFunction returns the tpl function, rendering a string as a template
+// with the given data, e.g. {{ tpl .banner . }}. The string can include
+// the templates of tpl.
+func (templateEngine *TemplateEngine) tplFunction(tpl *template.Template) func(string, interface{}) (string, error) {
+	return func(text string, data interface{}) (string, error) {
+		// The string is parsed in a copy of the set, left unchanged.
+		set, err := tpl.Clone()
+		if err != nil {
+			return "", err
+		}
+		t, err := set.New(tplName).Parse(text)
+		if err != nil {
+			return "", err
+		}
+		if templateEngine.Strict {
+			t.Option("missingkey=error")
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := t.Execute(buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+}
+
+// execErrors returns the execution errors of err, from the outermost to
+// the innermost, as the include and tpl functions nest executions.
+func execErrors(err error) []template.ExecError {
+	var execErrs []template.ExecError
+	for {
+		var e template.ExecError
+		if !errors.As(err, &e) {
+			return execErrs
+		}
+		execErrs = append(execErrs, e)
+		err = e.Err
+	}
+}
+
+// locateFailure returns the error of a fail or required call as a
+// FailError located at the innermost call in a file, or err otherwise.
+func locateFailure(err error) error {
+	var f *failure
+	if !errors.As(err, &f) {
+		return err
+	}
+	failErr := &FailError{Message: f.message}
+	for _, execErr := range execErrors(err) {
+		m := locationRegexp.FindStringSubmatch(execErr.Err.Error())
+		// Strings rendered by tpl are located at the tpl call.
+		if m == nil || m[1] == tplName {
+			continue
+		}
+		failErr.Template = m[1]
+		failErr.Line, _ = strconv.Atoi(m[2])
+		failErr.Column, _ = strconv.Atoi(m[3])
+	}
+	if failErr.Template == "" {
+		return err
+	}
+	return failErr
+}
@@ -0,0 +1,128 @@
+package templateengine
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// DuplicatePolicy tells what happens when a template is defined more
+// than once, the last definition replacing the previous ones.
+type DuplicatePolicy string
+
+const (
+	// DuplicateWarn logs the definitions of the template.
+	DuplicateWarn DuplicatePolicy = "warn"
+	// DuplicateError fails the parsing.
+	DuplicateError DuplicatePolicy = "error"
+)
+
+// Validate returns an error if the policy is unknown.
+func (p DuplicatePolicy) Validate() error {
+	switch p {
+	case "", DuplicateWarn, DuplicateError:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate templates policy %q, expected %q or %q", p, DuplicateWarn, DuplicateError)
+}
+
+// Definition locates the definition of a template.
+type Definition struct {
+	Name string
+	// File is the path of the file relative to the pattern
+	// directory, or the source of the main template.
+	File string
+	Line int
+	// Pattern is the reference of the pattern directory, e.g.
+	// a git URL, empty for the main template.
+	Pattern string
+}
+
+func (d Definition) String() string {
+	if d.Pattern == "" {
+		return fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	return fmt.Sprintf("%s:%d (pattern %s)", d.File, d.Line, d.Pattern)
+}
+
+// Index holds the definitions of the templates by name,
+// in the order they are parsed.
+type Index map[string][]Definition
+
+// add records the definition of a template by a parse tree,
+// unless the tree is empty as it replaces no template then.
+func (i Index) add(name string, tree *parse.Tree, file string, pattern string) {
+	if parse.IsEmptyTree(tree.Root) {
+		return
+	}
+	i[name] = append(i[name], Definition{Name: name, File: file, Line: line(tree), Pattern: pattern})
+}
+
+// line returns the line the tree starts at.
+func line(tree *parse.Tree) int {
+	// The location is name:line:column, the name may hold colons.
+	location, _ := tree.ErrorContext(tree.Root)
+	fields := strings.Split(location, ":")
+	if len(fields) < 3 {
+		return 0
+	}
+	n, _ := strconv.Atoi(fields[len(fields)-2])
+	return n
+}
+
+// Duplicates returns the definitions of the templates
+// defined more than once, sorted by name.
+func (i Index) Duplicates() [][]Definition {
+	var duplicates [][]Definition
+	for _, definitions := range i {
+		if len(definitions) > 1 {
+			duplicates = append(duplicates, definitions)
+		}
+	}
+	sort.Slice(duplicates, func(a, b int) bool {
+		return duplicates[a][0].Name < duplicates[b][0].Name
+	})
+	return duplicates
+}
+
+// check applies the policy to the duplicate definitions.
+func (i Index) check(policy DuplicatePolicy) error {
+	duplicates := i.Duplicates()
+	if len(duplicates) == 0 {
+		return nil
+	}
+	if policy == DuplicateError {
+		return &DuplicateTemplatesError{Definitions: duplicates}
+	}
+	for _, definitions := range duplicates {
+		slog.Warn("Template defined more than once, the last definition is used",
+			"template", definitions[0].Name, "definitions", joinDefinitions(definitions))
+	}
+	return nil
+}
+
+func joinDefinitions(definitions []Definition) string {
+	locations := make([]string, len(definitions))
+	for i, definition := range definitions {
+		locations[i] = definition.String()
+	}
+	return strings.Join(locations, ", ")
+}
+
+// DuplicateTemplatesError is returned by Parse when templates are
+// defined more than once and the policy is DuplicateError.
+type DuplicateTemplatesError struct {
+	// Definitions holds the definitions of each duplicate template.
+	Definitions [][]Definition
+}
+
+func (e *DuplicateTemplatesError) Error() string {
+	lines := []string{fmt.Sprintf("%d template(s) defined more than once:", len(e.Definitions))}
+	for _, definitions := range e.Definitions {
+		lines = append(lines, fmt.Sprintf("  %q: %s", definitions[0].Name, joinDefinitions(definitions)))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,158 @@
+package templateengine
+
+import (
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+)
+
+// Library is a set of pattern files. The templates of a library with a
+// namespace are named after it, e.g. "corp/header" for the "header"
+// template of the "corp" library.
+type Library struct {
+	Namespace string
+	// Source is the reference of the pattern directory, e.g. a git URL.
+	Source string
+	// Root is the local directory of the pattern, holding the files.
+	Root  string
+	Files []string
+}
+
+// patternFile holds the templates parsed from a pattern file.
+type patternFile struct {
+	path      string
+	library   Library
+	templates []*template.Template
+}
+
+func namespaced(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// addSource parses the main template and adds it to tpl,
+// along with the templates it defines.
+func addSource(tpl *template.Template, name string, text string, funcs template.FuncMap, index Index) error {
+	set, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, t := range set.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		index.add(t.Name(), t.Tree, name, "")
+		if _, err := tpl.AddParseTree(t.Name(), t.Tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addPatterns parses the files of the libraries and adds their templates
+// to tpl in order, so that a template defined again replaces the previous
+// one, recording them in index. Within a namespace, the template actions
+// and the include calls referencing a template of the namespace are
+// renamed as well, so that a library keeps calling its own templates.
+func addPatterns(tpl *template.Template, libraries []Library, funcs template.FuncMap, index Index) error {
+	var files []patternFile
+	// Names of the templates defined in each namespace.
+	local := map[string]map[string]bool{}
+	for _, library := range libraries {
+		for _, path := range library.Files {
+			set, err := template.New(filepath.Base(path)).Funcs(funcs).ParseFiles(path)
+			if err != nil {
+				return err
+			}
+			files = append(files, patternFile{path: path, library: library, templates: set.Templates()})
+			if library.Namespace == "" {
+				continue
+			}
+			if local[library.Namespace] == nil {
+				local[library.Namespace] = map[string]bool{}
+			}
+			for _, t := range set.Templates() {
+				local[library.Namespace][t.Name()] = true
+			}
+		}
+	}
+
+	for _, file := range files {
+		namespace := file.library.Namespace
+		relativePath, err := filepath.Rel(file.library.Root, file.path)
+		if err != nil {
+			relativePath = file.path
+		}
+		for _, t := range file.templates {
+			if t.Tree == nil {
+				continue
+			}
+			if namespace != "" {
+				namespaceNode(t.Tree.Root, namespace, local[namespace])
+			}
+			name := namespaced(namespace, t.Name())
+			index.add(name, t.Tree, relativePath, file.library.Source)
+			if _, err := tpl.AddParseTree(name, t.Tree); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// namespaceNode renames the references of node to the local templates.
+func namespaceNode(node parse.Node, namespace string, local map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			namespaceNode(child, namespace, local)
+		}
+	case *parse.ActionNode:
+		namespaceNode(n.Pipe, namespace, local)
+	case *parse.IfNode:
+		namespaceBranch(&n.BranchNode, namespace, local)
+	case *parse.RangeNode:
+		namespaceBranch(&n.BranchNode, namespace, local)
+	case *parse.WithNode:
+		namespaceBranch(&n.BranchNode, namespace, local)
+	case *parse.TemplateNode:
+		if local[n.Name] {
+			n.Name = namespaced(namespace, n.Name)
+		}
+		namespaceNode(n.Pipe, namespace, local)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			namespaceNode(cmd, namespace, local)
+		}
+	case *parse.ChainNode:
+		namespaceNode(n.Node, namespace, local)
+	case *parse.CommandNode:
+		// {{ include "name" . }}
+		if len(n.Args) > 1 {
+			function, isIdentifier := n.Args[0].(*parse.IdentifierNode)
+			name, isString := n.Args[1].(*parse.StringNode)
+			if isIdentifier && function.Ident == "include" && isString && local[name.Text] {
+				name.Text = namespaced(namespace, name.Text)
+				name.Quoted = strconv.Quote(name.Text)
+			}
+		}
+		for _, arg := range n.Args {
+			namespaceNode(arg, namespace, local)
+		}
+	}
+}
+
+func namespaceBranch(n *parse.BranchNode, namespace string, local map[string]bool) {
+	namespaceNode(n.Pipe, namespace, local)
+	namespaceNode(n.List, namespace, local)
+	namespaceNode(n.ElseList, namespace, local)
+}
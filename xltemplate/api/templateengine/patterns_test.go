@@ -0,0 +1,118 @@
+package templateengine
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeLibrary writes the pattern files of a library in a new directory.
+func writeLibrary(t *testing.T, namespace string, files map[string]string) Library {
+	t.Helper()
+	root := t.TempDir()
+	library := Library{Namespace: namespace, Source: root, Root: root}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		library.Files = append(library.Files, path)
+	}
+	return library
+}
+
+// TestNamespacedPatterns pins that the templates of aliased patterns are
+// named after their alias, and keep calling their own templates, whatever
+// the duplicates policy.
+func TestNamespacedPatterns(t *testing.T) {
+	library := func(namespace string) Library {
+		return writeLibrary(t, namespace, map[string]string{
+			"h.tmpl": `{{ define "h" }}` + namespace + `-{{ template "inner" . }}-{{ include "inner" . }}-{{ include "shared" . }}{{ end }}` +
+				`{{ define "inner" }}` + namespace + `{{ end }}`,
+		})
+	}
+	patterns := []Library{library("corp"), library("team")}
+	source := `{{ define "shared" }}shared{{ end }}{{ include "corp/h" . }} {{ template "team/h" . }} {{ template "corp/inner" . }}`
+
+	for _, policy := range []DuplicatePolicy{"", DuplicateWarn, DuplicateError} {
+		t.Run(string(policy), func(t *testing.T) {
+			engine := NewTemplateEngine("src", map[string]interface{}{}, source, patterns)
+			engine.Duplicates = policy
+			result, err := engine.Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "corp-corp-corp-shared team-team-team-shared corp"; result != want {
+				t.Errorf("got %q, want %q", result, want)
+			}
+		})
+	}
+}
+
+// TestDuplicateTemplates pins the policies of the templates defined more
+// than once: the last definition wins with a warning, or the parsing fails.
+func TestDuplicateTemplates(t *testing.T) {
+	library := func(namespace string, value string) Library {
+		return writeLibrary(t, namespace, map[string]string{"h.tmpl": "\n" + `{{ define "h" }}` + value + `{{ end }}`})
+	}
+	tests := []struct {
+		name     string
+		patterns []Library
+		template string
+	}{
+		{
+			name:     "patterns",
+			patterns: []Library{library("", "first"), library("", "second")},
+			template: "h",
+		},
+		{
+			name:     "patterns with the same alias",
+			patterns: []Library{library("corp", "first"), library("corp", "second")},
+			template: "corp/h",
+		},
+	}
+	for _, test := range tests {
+		source := `{{ include "` + test.template + `" . }}`
+		for _, policy := range []DuplicatePolicy{"", DuplicateWarn} {
+			t.Run(test.name+" "+string(policy), func(t *testing.T) {
+				var logs bytes.Buffer
+				defer slog.SetDefault(slog.Default())
+				slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+				engine := NewTemplateEngine("src", map[string]interface{}{}, source, test.patterns)
+				engine.Duplicates = policy
+				result, err := engine.Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result != "second" {
+					t.Errorf("got %q, want %q", result, "second")
+				}
+				if !strings.Contains(logs.String(), "Template defined more than once") {
+					t.Errorf("expected a warning, got %q", logs.String())
+				}
+			})
+		}
+		t.Run(test.name+" error", func(t *testing.T) {
+			engine := NewTemplateEngine("src", map[string]interface{}{}, source, test.patterns)
+			engine.Duplicates = DuplicateError
+			_, err := engine.Parse()
+			var duplicatesErr *DuplicateTemplatesError
+			if !errors.As(err, &duplicatesErr) {
+				t.Fatalf("expected duplicate templates, got %v", err)
+			}
+			want := [][]Definition{{
+				{Name: test.template, File: "h.tmpl", Line: 2, Pattern: test.patterns[0].Source},
+				{Name: test.template, File: "h.tmpl", Line: 2, Pattern: test.patterns[1].Source},
+			}}
+			if !reflect.DeepEqual(duplicatesErr.Definitions, want) {
+				t.Errorf("got %v, want %v", duplicatesErr.Definitions, want)
+			}
+		})
+	}
+}
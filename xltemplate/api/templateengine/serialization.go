@@ -0,0 +1,95 @@
+package templateengine
+
+import (
+	"strings"
+	"text/template"
+
+	"do3b/xltemplate/api/values"
+)
+
+// serializationFuncs returns the functions converting values to and from
+// YAML, TOML and JSON, as in Helm. Keys are sorted when encoding. On error,
+// the to* functions return the error message, or an empty string for
+// toYaml, the from* functions return a map holding the message under
+// "Error", or a list of the message, while the must* variants fail.
+func serializationFuncs() template.FuncMap {
+	return template.FuncMap{
+		"toYaml": func(value interface{}) string {
+			s, _ := encode(value, values.FormatYAML)
+			return s
+		},
+		"mustToYaml": func(value interface{}) (string, error) {
+			return encode(value, values.FormatYAML)
+		},
+		"toToml": func(value interface{}) string {
+			s, err := encode(value, values.FormatTOML)
+			if err != nil {
+				return err.Error()
+			}
+			return s
+		},
+		"mustToToml": func(value interface{}) (string, error) {
+			return encode(value, values.FormatTOML)
+		},
+
+		"fromYaml":          decodeFunc(values.FormatYAML),
+		"mustFromYaml":      mustDecodeFunc(values.FormatYAML),
+		"fromYamlArray":     decodeListFunc(values.FormatYAML),
+		"mustFromYamlArray": mustDecodeListFunc(values.FormatYAML),
+		"fromToml":          decodeFunc(values.FormatTOML),
+		"mustFromToml":      mustDecodeFunc(values.FormatTOML),
+		"fromJson":          decodeFunc(values.FormatJSON),
+		"mustFromJson":      mustDecodeFunc(values.FormatJSON),
+		"fromJsonArray":     decodeListFunc(values.FormatJSON),
+		"mustFromJsonArray": mustDecodeListFunc(values.FormatJSON),
+	}
+}
+
+// encode returns the value in the format, without the trailing
+// newline so that it can be indented, e.g. with nindent.
+func encode(value interface{}, format values.Format) (string, error) {
+	data, err := values.Encode(value, format)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func decodeFunc(format values.Format) func(string) map[string]interface{} {
+	return func(s string) map[string]interface{} {
+		result, err := values.Decode([]byte(s), format)
+		if err != nil {
+			return map[string]interface{}{"Error": err.Error()}
+		}
+		if result == nil {
+			result = map[string]interface{}{}
+		}
+		return result
+	}
+}
+
+func mustDecodeFunc(format values.Format) func(string) (map[string]interface{}, error) {
+	return func(s string) (map[string]interface{}, error) {
+		result, err := values.Decode([]byte(s), format)
+		if result == nil && err == nil {
+			result = map[string]interface{}{}
+		}
+		return result, err
+	}
+}
+
+func decodeListFunc(format values.Format) func(string) []interface{} {
+	return func(s string) []interface{} {
+		result, err := values.DecodeList([]byte(s), format)
+		if err != nil {
+			return []interface{}{err.Error()}
+		}
+		return result
+	}
+}
+
+func mustDecodeListFunc(format values.Format) func(string) ([]interface{}, error) {
+	return func(s string) ([]interface{}, error) {
+		return values.DecodeList([]byte(s), format)
+	}
+}
@@ -0,0 +1,190 @@
+package templateengine
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// MissingKey locates a variable referenced by a template
+// but absent from the variables map.
+type MissingKey struct {
+	Template string
+	Line     int
+	Column   int
+	Key      string
+}
+
+func (m MissingKey) location() string {
+	return fmt.Sprintf("%s:%d:%d", m.Template, m.Line, m.Column)
+}
+
+func (m MissingKey) String() string {
+	return fmt.Sprintf("%s: missing key %q", m.location(), m.Key)
+}
+
+// MissingKeysError is returned by Parse in strict mode when
+// the templates reference one or more missing variables.
+type MissingKeysError struct {
+	Keys []MissingKey
+}
+
+func (e *MissingKeysError) Error() string {
+	lines := []string{fmt.Sprintf("%d missing key(s) in strict mode:", len(e.Keys))}
+	for _, key := range e.Keys {
+		lines = append(lines, "  "+key.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+var missingKeyRegexp = regexp.MustCompile(`: map has no entry for key "(.*)"$`)
+
+// stub locates the node replaced by an empty string so that the next
+// execution goes further: a node of the tree of the template executing
+// it, either the missing key or the tpl call rendering a string with it.
+type stub struct {
+	template string
+	location string
+	call     bool
+}
+
+// executeStrict runs the template with missingkey=error. Go templates stop
+// at the first missing key, so every time one is hit the offending node is
+// replaced by an empty string and the execution is restarted, until the
+// template renders or fails for another reason.
+func (templateEngine *TemplateEngine) executeStrict(tpl *template.Template, data map[string]interface{}) (string, error) {
+	for _, t := range tpl.Templates() {
+		t.Option("missingkey=error")
+	}
+
+	var missingKeys []MissingKey
+	for {
+		result := bytes.NewBuffer(nil)
+		err := tpl.ExecuteTemplate(result, templateEngine.TemplateName, data)
+		if err == nil && len(missingKeys) == 0 {
+			return result.String(), nil
+		}
+		if err == nil {
+			return "", &MissingKeysError{Keys: missingKeys}
+		}
+
+		missingKey, s, ok := parseMissingKey(err)
+		if !ok || !stubMissingKey(tpl, s) {
+			// Once a key has been stubbed, later errors are most likely
+			// caused by the stub itself, so only report the missing keys.
+			if len(missingKeys) > 0 {
+				return "", &MissingKeysError{Keys: missingKeys}
+			}
+			return "", err
+		}
+		missingKeys = append(missingKeys, missingKey)
+	}
+}
+
+// parseMissingKey extracts the missing key from the innermost execution
+// error, as the include and tpl functions nest executions. Keys missing
+// from a string rendered by tpl are located at the tpl call.
+func parseMissingKey(err error) (MissingKey, stub, bool) {
+	execErrs := execErrors(err)
+	if len(execErrs) == 0 {
+		return MissingKey{}, stub{}, false
+	}
+	innermost := len(execErrs) - 1
+	m := missingKeyRegexp.FindStringSubmatch(execErrs[innermost].Err.Error())
+	if m == nil {
+		return MissingKey{}, stub{}, false
+	}
+	key := m[1]
+
+	for i := innermost; i >= 0; i-- {
+		location := locationRegexp.FindStringSubmatch(execErrs[i].Err.Error())
+		if location == nil || location[1] == tplName {
+			continue
+		}
+		line, _ := strconv.Atoi(location[2])
+		column, _ := strconv.Atoi(location[3])
+		missingKey := MissingKey{Template: location[1], Line: line, Column: column, Key: key}
+		return missingKey, stub{template: execErrs[i].Name, location: missingKey.location(), call: i < innermost}, true
+	}
+	return MissingKey{}, stub{}, false
+}
+
+// stubMissingKey replaces the node referencing the missing key with an empty
+// string so the next execution can go further.
+func stubMissingKey(tpl *template.Template, s stub) bool {
+	t := tpl.Lookup(s.template)
+	if t == nil || t.Tree == nil {
+		return false
+	}
+	return stubNode(t.Tree, t.Tree.Root, s)
+}
+
+func emptyString(pos parse.Pos) *parse.StringNode {
+	return &parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: `""`}
+}
+
+func stubNode(tree *parse.Tree, node parse.Node, s stub) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if stubNode(tree, child, s) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return stubNode(tree, n.Pipe, s)
+	case *parse.IfNode:
+		return stubBranch(tree, &n.BranchNode, s)
+	case *parse.RangeNode:
+		return stubBranch(tree, &n.BranchNode, s)
+	case *parse.WithNode:
+		return stubBranch(tree, &n.BranchNode, s)
+	case *parse.TemplateNode:
+		return stubNode(tree, n.Pipe, s)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if stubNode(tree, cmd, s) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return stubNode(tree, n.Node, s)
+	case *parse.CommandNode:
+		// Function calls are located at their command.
+		if s.call {
+			if location, _ := tree.ErrorContext(n); location == s.location {
+				n.Args = []parse.Node{emptyString(n.Position())}
+				return true
+			}
+		}
+		for i, arg := range n.Args {
+			switch arg.(type) {
+			case *parse.FieldNode, *parse.ChainNode, *parse.VariableNode:
+				if location, _ := tree.ErrorContext(arg); !s.call && location == s.location {
+					n.Args[i] = emptyString(arg.Position())
+					return true
+				}
+			}
+			if stubNode(tree, arg, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stubBranch(tree *parse.Tree, n *parse.BranchNode, s stub) bool {
+	return stubNode(tree, n.Pipe, s) ||
+		stubNode(tree, n.List, s) ||
+		stubNode(tree, n.ElseList, s)
+}
@@ -0,0 +1,119 @@
+package templateengine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStrictMissingKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		variables map[string]interface{}
+		want      []MissingKey
+	}{
+		{
+			name:   "actions",
+			source: "{{ .a }} {{ .x }} {{ .y }}",
+			want:   []MissingKey{{"src", 1, 12, "x"}, {"src", 1, 21, "y"}},
+		},
+		{
+			name:      "nested keys",
+			source:    "{{ .db.host }}",
+			variables: map[string]interface{}{"db": map[string]interface{}{}},
+			want:      []MissingKey{{"src", 1, 6, "host"}},
+		},
+		{
+			name:   "pipelines",
+			source: "{{ .x | upper }} {{ printf \"%s-%s\" .a .y }}",
+			want:   []MissingKey{{"src", 1, 3, "x"}, {"src", 1, 38, "y"}},
+		},
+		{
+			name:      "range and with",
+			source:    "{{ range .list }}{{ .x }}{{ end }}{{ with .db }}{{ .y }}{{ end }}",
+			variables: map[string]interface{}{"list": []interface{}{map[string]interface{}{}}, "db": map[string]interface{}{"z": 1}},
+			want:      []MissingKey{{"src", 1, 20, "x"}, {"src", 1, 51, "y"}},
+		},
+		{
+			name:   "conditions",
+			source: "{{ if .x }}{{ else }}{{ .y }}{{ end }}",
+			want:   []MissingKey{{"src", 1, 6, "x"}, {"src", 1, 24, "y"}},
+		},
+		{
+			name:   "template calls",
+			source: "{{ define \"t\" }}{{ .x }}{{ end }}{{ template \"t\" . }} {{ include \"t\" . }}",
+			want:   []MissingKey{{"src", 1, 19, "x"}},
+		},
+		{
+			name:      "tpl",
+			source:    "{{ tpl .banner . }}-{{ .a }}",
+			variables: map[string]interface{}{"banner": "{{ .x }}"},
+			want:      []MissingKey{{"src", 1, 3, "x"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			variables := map[string]interface{}{"a": "a"}
+			for key, value := range test.variables {
+				variables[key] = value
+			}
+			engine := NewTemplateEngine("src", variables, test.source, nil)
+			engine.Strict = true
+			_, err := engine.Parse()
+			var missingKeysErr *MissingKeysError
+			if !errors.As(err, &missingKeysErr) {
+				t.Fatalf("expected missing keys, got %v", err)
+			}
+			if !reflect.DeepEqual(missingKeysErr.Keys, test.want) {
+				t.Errorf("got %v, want %v", missingKeysErr.Keys, test.want)
+			}
+		})
+	}
+}
+
+func TestStrictRenders(t *testing.T) {
+	engine := NewTemplateEngine("src", map[string]interface{}{"a": "a", "banner": "{{ .a }}"}, "{{ .a }}-{{ tpl .banner . }}", nil)
+	engine.Strict = true
+	result, err := engine.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "a-a" {
+		t.Errorf("got %q, want %q", result, "a-a")
+	}
+}
+
+// TestStrictSameFileNames pins that a missing key is looked up in the tree
+// of the template executing it, not in a file of the same name.
+func TestStrictSameFileNames(t *testing.T) {
+	dir := t.TempDir()
+	library := func(namespace string, content string) Library {
+		root := filepath.Join(dir, namespace)
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(root, "h.tmpl")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return Library{Namespace: namespace, Root: root, Files: []string{path}}
+	}
+	patterns := []Library{
+		library("first", `{{ define "h" }}{{ .y }}{{ end }}`),
+		library("second", `{{ define "h" }}{{ .x }}{{ end }}`),
+	}
+	engine := NewTemplateEngine("src", map[string]interface{}{}, `{{ include "first/h" . }}{{ include "second/h" . }}`, patterns)
+	engine.Strict = true
+	_, err := engine.Parse()
+	var missingKeysErr *MissingKeysError
+	if !errors.As(err, &missingKeysErr) {
+		t.Fatalf("expected missing keys, got %v", err)
+	}
+	want := []MissingKey{{"h.tmpl", 1, 19, "y"}, {"h.tmpl", 1, 19, "x"}}
+	if !reflect.DeepEqual(missingKeysErr.Keys, want) {
+		t.Errorf("got %v, want %v", missingKeysErr.Keys, want)
+	}
+}
@@ -2,7 +2,6 @@ package templateengine
 
 import (
 	"bytes"
-	"fmt"
 	"log/slog"
 	"text/template"
 
@@ -15,11 +14,20 @@ type TemplateEngine struct {
 	TemplateName string
 	Variables    map[string]interface{}
 	Source       string
-	Patterns     []string
+	Patterns     []Library
+
+	// Strict fails the execution on missing variables
+	// instead of rendering them as <no value>.
+	Strict bool
+	// Duplicates tells what happens when a template is defined
+	// more than once, DuplicateWarn if empty.
+	Duplicates DuplicatePolicy
+	// Files, if there are any, are given to the templates under FilesKey.
+	Files *Files
 }
 
 func NewTemplateEngine(
-	templateName string, variables map[string]interface{}, source string, patterns []string) *TemplateEngine {
+	templateName string, variables map[string]interface{}, source string, patterns []Library) *TemplateEngine {
 	return &TemplateEngine{
 		TemplateName: templateName,
 		Variables:    variables,
@@ -35,35 +43,76 @@ func (templateEngine *TemplateEngine) Parse() (string, error) {
 	slog.Debug("Loading variables from file", "variables", templateEngine.Variables)
 	slog.Debug("Loading source file", "source", templateEngine.Source)
 	slog.Debug("Loading patterns", "patterns", templateEngine.Patterns)
-	// Add custom include and sprig lib functions to the template
+	// Add custom include, tpl, required and fail, serialization and sprig lib functions to the template
 	var funcMap template.FuncMap = map[string]interface{}{}
 	funcMap["include"] = func(name string, data interface{}) (string, error) {
 		buf := bytes.NewBuffer(nil)
 		if err := tpl.ExecuteTemplate(buf, name, data); err != nil {
-			fmt.Println(err.Error())
+			slog.Debug("Error executing included template", "name", name, "error", err)
 			return "", err
 		}
 		return buf.String(), nil
 	}
+	funcMap["tpl"] = templateEngine.tplFunction(&tpl)
+	funcMap["required"] = required
+	funcMap["fail"] = fail
 
-	tpl.Funcs(sprig.TxtFuncMap()).Funcs(funcMap)
+	funcs := sprig.TxtFuncMap()
+	for name, function := range serializationFuncs() {
+		funcs[name] = function
+	}
+	for name, function := range funcMap {
+		funcs[name] = function
+	}
+	tpl.Funcs(funcs)
 
 	// Create the main template from the source
-	tpl.New(templateEngine.TemplateName).Parse(templateEngine.Source)
+	index := Index{}
+	err = addSource(&tpl, templateEngine.TemplateName, templateEngine.Source, funcs, index)
+	if err != nil {
+		return "", err
+	}
 
 	// Add patterns to template
-	_, err = tpl.ParseFiles(templateEngine.Patterns...)
+	err = addPatterns(&tpl, templateEngine.Patterns, funcs, index)
 	if err != nil {
 		return "", err
 	}
+	if err = index.check(templateEngine.Duplicates); err != nil {
+		return "", err
+	}
+
+	data := templateEngine.data()
+	if templateEngine.Strict {
+		result, err := templateEngine.executeStrict(&tpl, data)
+		return result, locateFailure(err)
+	}
 
 	result := bytes.NewBuffer(nil)
-	err = tpl.ExecuteTemplate(result, templateEngine.TemplateName, templateEngine.Variables)
+	err = tpl.ExecuteTemplate(result, templateEngine.TemplateName, data)
 	if err != nil {
-		panic(err)
+		return "", locateFailure(err)
 	}
 
 	utils.NoValueScan(result.String())
 
 	return result.String(), nil
 }
+
+// data returns the data of the templates: the variables, along with
+// the files if there are any, unless a variable has the same name.
+func (templateEngine *TemplateEngine) data() map[string]interface{} {
+	if templateEngine.Files.Len() == 0 {
+		return templateEngine.Variables
+	}
+	if _, ok := templateEngine.Variables[FilesKey]; ok {
+		slog.Warn("Variable hides the files of the patterns", "variable", FilesKey)
+		return templateEngine.Variables
+	}
+	data := make(map[string]interface{}, len(templateEngine.Variables)+1)
+	for key, value := range templateEngine.Variables {
+		data[key] = value
+	}
+	data[FilesKey] = templateEngine.Files
+	return data
+}
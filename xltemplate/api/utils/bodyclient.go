@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// BodyClient returns a copy of next reading the body of the successful
+// responses, e.g. to hash or copy the fetched files, and passing it to
+// inspect before the caller reads it. An error of inspect fails the
+// request.
+func BodyClient(next *http.Client, inspect func(req *http.Request, body []byte) error) *http.Client {
+	if next == nil {
+		next = &http.Client{}
+	}
+	client := *next
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = &bodyTransport{inspect: inspect, next: transport}
+	return &client
+}
+
+type bodyTransport struct {
+	inspect func(req *http.Request, body []byte) error
+	next    http.RoundTripper
+}
+
+func (t *bodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.inspect(req, body); err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
@@ -0,0 +1,217 @@
+package values
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/roboll/helmfile/pkg/maputil"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"gopkg.in/yaml.v2"
+)
+
+// Format of a variables file.
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+	// FormatHCL only supports attributes, as in Terraform .tfvars files.
+	FormatHCL Format = "hcl"
+)
+
+// FormatFromPath returns the format matching the extension of
+// the file path or URL, or YAML if the extension is unknown.
+func FormatFromPath(filePath string) Format {
+	if u, err := url.Parse(filePath); err == nil && u.Scheme != "" && u.Path != "" {
+		filePath = u.Path
+	}
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	case ".hcl", ".tfvars":
+		return FormatHCL
+	default:
+		return FormatYAML
+	}
+}
+
+// ParseFormat validates a format name. An empty name yields an empty
+// format, meaning the format is chosen from the file extension.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case "", FormatYAML, FormatJSON, FormatTOML, FormatHCL:
+		return format, nil
+	case "yml":
+		return FormatYAML, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected %q, %q, %q or %q",
+			name, FormatYAML, FormatJSON, FormatTOML, FormatHCL)
+	}
+}
+
+// Decode decodes data in the given format into a variables tree, so
+// templates get the same nested map[string]interface{}, []interface{}
+// and int values whatever the format.
+func Decode(data []byte, format Format) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	switch format {
+	case FormatYAML, "":
+		if err := yaml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+		}
+		// Convert decoded yaml value so nested map are all map[string]{interface} instead of map[interface{}]interface{}
+		var err error
+		result, err = maputil.CastKeysToStrings(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to cast keys to strings: %w", err)
+		}
+		return result, nil
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal TOML: %w", err)
+		}
+	case FormatHCL:
+		var err error
+		if result, err = decodeHCL(data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal HCL: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	normalized, _ := normalize(result).(map[string]interface{})
+	return normalized, nil
+}
+
+// DecodeList decodes a YAML or JSON list into the items of a variables
+// tree, converted as by Decode.
+func DecodeList(data []byte, format Format) ([]interface{}, error) {
+	var result []interface{}
+	switch format {
+	case FormatYAML, "":
+		if err := yaml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+		}
+		// Keys are cast in a document holding the list.
+		document, err := maputil.CastKeysToStrings(map[string]interface{}{"list": result})
+		if err != nil {
+			return nil, fmt.Errorf("failed to cast keys to strings: %w", err)
+		}
+		result, _ = document["list"].([]interface{})
+		return result, nil
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q for a list, expected %q or %q", format, FormatYAML, FormatJSON)
+	}
+
+	normalized, _ := normalize(result).([]interface{})
+	return normalized, nil
+}
+
+// Encode encodes a value in the given format. The keys of the
+// maps are sorted, so that the output is stable.
+func Encode(value interface{}, format Format) ([]byte, error) {
+	switch format {
+	case FormatYAML, "":
+		return yaml.Marshal(value)
+	case FormatJSON:
+		return json.Marshal(value)
+	case FormatTOML:
+		buf := bytes.NewBuffer(nil)
+		if err := toml.NewEncoder(buf).Encode(value); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q for encoding", format)
+	}
+}
+
+// decodeHCL evaluates the attributes of an HCL body, without
+// variables nor functions, and converts them through JSON.
+func decodeHCL(data []byte) (map[string]interface{}, error) {
+	file, diags := hclsyntax.ParseConfig(data, "variables.hcl", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	attributes, diags := file.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	result := map[string]interface{}{}
+	for name, attribute := range attributes {
+		value, diags := attribute.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		encoded, err := ctyjson.Marshal(value, value.Type())
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		decoder.UseNumber()
+		var decoded interface{}
+		if err := decoder.Decode(&decoded); err != nil {
+			return nil, err
+		}
+		result[name] = decoded
+	}
+	return result, nil
+}
+
+// normalize converts the values produced by the JSON and TOML
+// decoders to the ones produced by the YAML decoder.
+func normalize(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = normalize(item)
+		}
+		return typed
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = normalize(item)
+		}
+		return typed
+	case []map[string]interface{}:
+		list := make([]interface{}, len(typed))
+		for i, item := range typed {
+			list[i] = normalize(item)
+		}
+		return list
+	case json.Number:
+		if i, err := typed.Int64(); err == nil {
+			return int(i)
+		}
+		if f, err := typed.Float64(); err == nil {
+			return f
+		}
+		return typed.String()
+	case int64:
+		return int(typed)
+	default:
+		return value
+	}
+}
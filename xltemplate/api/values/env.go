@@ -0,0 +1,122 @@
+package values
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// envKeySeparator separates nested keys in environment variable names.
+const envKeySeparator = "__"
+
+// FromEnv builds a variables tree from KEY=VALUE entries, as returned by
+// os.Environ, whose key starts with prefix. The prefix is removed and double
+// underscores separate nested keys, e.g. with the XLT_VAR_ prefix,
+// XLT_VAR_db__host=localhost becomes {db: {host: localhost}}.
+// Values are kept as strings.
+func FromEnv(environ []string, prefix string) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for _, entry := range environ {
+		key, value, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, prefix)
+		if key == "" {
+			continue
+		}
+		keys := strings.Split(key, envKeySeparator)
+		if err := SetKeys(variables, keys, value); err != nil {
+			return nil, fmt.Errorf("invalid environment variable %q: %w", prefix+key, err)
+		}
+	}
+	return variables, nil
+}
+
+// SetKeys sets value in dest at the path made of the given map keys,
+// creating the intermediate maps as needed.
+func SetKeys(dest map[string]interface{}, keys []string, value interface{}) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	elements := make([]pathElement, len(keys))
+	for i, key := range keys {
+		if key == "" {
+			return fmt.Errorf("empty key in path %q", strings.Join(keys, "."))
+		}
+		elements[i] = pathElement{key: key, index: -1}
+	}
+	_, err := setElements(dest, elements, value)
+	return err
+}
+
+// ParseDotEnv parses the content of a .env file into KEY=VALUE entries.
+// Empty lines, comments and an optional export keyword are ignored.
+// Double quoted values support \n, \t, \" and \\ escapes, single quoted
+// values are taken literally.
+func ParseDotEnv(data []byte) ([]string, error) {
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineIndex := 0
+	for scanner.Scan() {
+		lineIndex++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineIndex)
+		}
+		value, err := parseDotEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineIndex, err)
+		}
+		entries = append(entries, key+"="+value)
+	}
+	return entries, scanner.Err()
+}
+
+func parseDotEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated quoted value")
+	}
+	// Unquoted values end at an inline comment.
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,129 @@
+package values
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		prefix  string
+		want    map[string]interface{}
+	}{
+		{
+			name:    "prefix removed",
+			environ: []string{"XLT_VAR_a=1", "OTHER=2", "XLT_VAR_=3", "XLT_VARb=4"},
+			prefix:  "XLT_VAR_",
+			want:    map[string]interface{}{"a": "1"},
+		},
+		{
+			name:    "nested keys",
+			environ: []string{"XLT_VAR_db__host=localhost", "XLT_VAR_db__port=5432", "XLT_VAR_A__B__C=x"},
+			prefix:  "XLT_VAR_",
+			want: map[string]interface{}{
+				"db": map[string]interface{}{"host": "localhost", "port": "5432"},
+				"A":  map[string]interface{}{"B": map[string]interface{}{"C": "x"}},
+			},
+		},
+		{
+			name:    "single underscores kept",
+			environ: []string{"XLT_VAR_log_level=debug"},
+			prefix:  "XLT_VAR_",
+			want:    map[string]interface{}{"log_level": "debug"},
+		},
+		{
+			name:    "values kept as strings",
+			environ: []string{"XLT_VAR_a=true", "XLT_VAR_b=a=b", "XLT_VAR_c="},
+			prefix:  "XLT_VAR_",
+			want:    map[string]interface{}{"a": "true", "b": "a=b", "c": ""},
+		},
+		{
+			name:    "no prefix",
+			environ: []string{"a__b=1"},
+			want:    map[string]interface{}{"a": map[string]interface{}{"b": "1"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := FromEnv(test.environ, test.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromEnvErrors(t *testing.T) {
+	for _, entry := range []string{"XLT_VAR_a____b=1", "XLT_VAR___a=1", "XLT_VAR_a__=1"} {
+		t.Run(entry, func(t *testing.T) {
+			if _, err := FromEnv([]string{entry}, "XLT_VAR_"); err == nil {
+				t.Errorf("expected an error for %q", entry)
+			}
+		})
+	}
+}
+
+func TestParseDotEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "entries",
+			data: "A=1\nB = two \n\n",
+			want: []string{"A=1", "B=two"},
+		},
+		{
+			name: "comments and export",
+			data: "# comment\n  # indented\nexport A=1\nB=2 # inline\nC=a#b\n",
+			want: []string{"A=1", "B=2", "C=a#b"},
+		},
+		{
+			name: "double quotes",
+			data: `A="x y # z"` + "\n" + `B="l1\nl2\t\"q\" \\"` + "\n" + `C="a" trailing`,
+			want: []string{"A=x y # z", "B=l1\nl2\t\"q\" \\", "C=a"},
+		},
+		{
+			name: "single quotes",
+			data: `A='x\n $y # z'` + "\n" + `B=''`,
+			want: []string{`A=x\n $y # z`, "B="},
+		},
+		{
+			name: "empty values",
+			data: "A=\nB=\"\"",
+			want: []string{"A=", "B="},
+		},
+		{
+			name: "equal signs in values",
+			data: "A=b=c",
+			want: []string{"A=b=c"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseDotEnv([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseDotEnvErrors(t *testing.T) {
+	for _, data := range []string{"A", "=1", `A="x`, "A='x", "A=1\nexport B"} {
+		t.Run(data, func(t *testing.T) {
+			if _, err := ParseDotEnv([]byte(data)); err == nil {
+				t.Errorf("expected an error for %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package values
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Strategy tells which value is kept when two variables trees
+// define the same key with values which are not both maps.
+type Strategy string
+
+const (
+	// StrategyOverride keeps the value of the tree merged last.
+	StrategyOverride Strategy = "override"
+	// StrategyKeep keeps the existing value, the merged tree
+	// only adds missing keys.
+	StrategyKeep Strategy = "keep"
+)
+
+// ListStrategy tells how two lists defined at the same key are merged.
+type ListStrategy string
+
+const (
+	// ListReplace applies the Strategy to lists as to any other value.
+	ListReplace ListStrategy = "replace"
+	// ListAppend appends the items of the merged list to the existing one.
+	ListAppend ListStrategy = "append"
+	// ListMerge merges the map items sharing the same value for the
+	// ListKey, and appends the others.
+	ListMerge ListStrategy = "merge"
+)
+
+const defaultListKey = "name"
+
+// MergeOptions define how a variables tree is merged into another.
+// Empty fields take the default value.
+type MergeOptions struct {
+	Strategy Strategy     `yaml:"strategy"`
+	Lists    ListStrategy `yaml:"lists"`
+	ListKey  string       `yaml:"listKey"`
+}
+
+// DefaultMergeOptions override values, replace lists and
+// merge list items by name when asked to.
+func DefaultMergeOptions() MergeOptions {
+	return MergeOptions{
+		Strategy: StrategyOverride,
+		Lists:    ListReplace,
+		ListKey:  defaultListKey,
+	}
+}
+
+// With returns the options overridden by the non-empty fields of other.
+func (o MergeOptions) With(other MergeOptions) MergeOptions {
+	if other.Strategy != "" {
+		o.Strategy = other.Strategy
+	}
+	if other.Lists != "" {
+		o.Lists = other.Lists
+	}
+	if other.ListKey != "" {
+		o.ListKey = other.ListKey
+	}
+	return o
+}
+
+// Validate returns an error if a field has an unknown value.
+func (o MergeOptions) Validate() error {
+	switch o.Strategy {
+	case "", StrategyOverride, StrategyKeep:
+	default:
+		return fmt.Errorf("unknown merge strategy %q, expected %q or %q",
+			o.Strategy, StrategyOverride, StrategyKeep)
+	}
+	switch o.Lists {
+	case "", ListReplace, ListAppend, ListMerge:
+	default:
+		return fmt.Errorf("unknown list merge strategy %q, expected %q, %q or %q",
+			o.Lists, ListReplace, ListAppend, ListMerge)
+	}
+	return nil
+}
+
+// Merge merges src into dst. Maps are merged recursively, lists according
+// to the list strategy and other values according to the strategy. A null
+// value in src deletes the key from dst, which allows to remove a key
+// defined by an included file.
+func Merge(dst map[string]interface{}, src map[string]interface{}, opts MergeOptions) error {
+	opts = DefaultMergeOptions().With(opts)
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	mergeMaps(dst, src, opts)
+	return nil
+}
+
+func mergeMaps(dst map[string]interface{}, src map[string]interface{}, opts MergeOptions) {
+	for key, srcValue := range src {
+		dstValue, exists := dst[key]
+		switch {
+		case srcValue == nil && exists:
+			delete(dst, key)
+		case !exists:
+			dst[key] = srcValue
+		default:
+			dst[key] = mergeValues(dstValue, srcValue, opts)
+		}
+	}
+}
+
+func mergeValues(dstValue interface{}, srcValue interface{}, opts MergeOptions) interface{} {
+	switch dstTyped := dstValue.(type) {
+	case map[string]interface{}:
+		if srcTyped, ok := srcValue.(map[string]interface{}); ok {
+			mergeMaps(dstTyped, srcTyped, opts)
+			return dstTyped
+		}
+	case []interface{}:
+		if srcTyped, ok := srcValue.([]interface{}); ok {
+			switch opts.Lists {
+			case ListAppend:
+				return append(dstTyped, srcTyped...)
+			case ListMerge:
+				return mergeLists(dstTyped, srcTyped, opts)
+			}
+		}
+	}
+	if opts.Strategy == StrategyKeep {
+		return dstValue
+	}
+	return srcValue
+}
+
+// mergeLists merges the map items of src into the map items of dst with the
+// same value for the list key. Other items are appended unless dst already
+// holds an equal item.
+func mergeLists(dst []interface{}, src []interface{}, opts MergeOptions) []interface{} {
+	for _, srcItem := range src {
+		if i := indexOfListItem(dst, srcItem, opts.ListKey); i >= 0 {
+			dst[i] = mergeValues(dst[i], srcItem, opts)
+			continue
+		}
+		dst = append(dst, srcItem)
+	}
+	return dst
+}
+
+func indexOfListItem(list []interface{}, item interface{}, listKey string) int {
+	itemMap, isMap := item.(map[string]interface{})
+	for i, candidate := range list {
+		if !isMap {
+			if reflect.DeepEqual(candidate, item) {
+				return i
+			}
+			continue
+		}
+		candidateMap, ok := candidate.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key, hasKey := itemMap[listKey]
+		if hasKey && key != nil && reflect.DeepEqual(candidateMap[listKey], key) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,136 @@
+package values
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  map[string]interface{}
+		src  map[string]interface{}
+		opts MergeOptions
+		want map[string]interface{}
+	}{
+		{
+			name: "override by default",
+			dst:  map[string]interface{}{"a": 1, "b": 1},
+			src:  map[string]interface{}{"a": 2, "c": 2},
+			want: map[string]interface{}{"a": 2, "b": 1, "c": 2},
+		},
+		{
+			name: "keep",
+			dst:  map[string]interface{}{"a": 1, "b": 1},
+			src:  map[string]interface{}{"a": 2, "c": 2},
+			opts: MergeOptions{Strategy: StrategyKeep},
+			want: map[string]interface{}{"a": 1, "b": 1, "c": 2},
+		},
+		{
+			name: "maps merged recursively",
+			dst:  map[string]interface{}{"db": map[string]interface{}{"host": "h", "port": 1}},
+			src:  map[string]interface{}{"db": map[string]interface{}{"port": 2}},
+			want: map[string]interface{}{"db": map[string]interface{}{"host": "h", "port": 2}},
+		},
+		{
+			name: "keep in nested maps",
+			dst:  map[string]interface{}{"db": map[string]interface{}{"port": 1}},
+			src:  map[string]interface{}{"db": map[string]interface{}{"host": "h", "port": 2}},
+			opts: MergeOptions{Strategy: StrategyKeep},
+			want: map[string]interface{}{"db": map[string]interface{}{"host": "h", "port": 1}},
+		},
+		{
+			name: "lists replaced by default",
+			dst:  map[string]interface{}{"l": []interface{}{1, 2}},
+			src:  map[string]interface{}{"l": []interface{}{3}},
+			want: map[string]interface{}{"l": []interface{}{3}},
+		},
+		{
+			name: "lists kept",
+			dst:  map[string]interface{}{"l": []interface{}{1, 2}},
+			src:  map[string]interface{}{"l": []interface{}{3}},
+			opts: MergeOptions{Strategy: StrategyKeep, Lists: ListReplace},
+			want: map[string]interface{}{"l": []interface{}{1, 2}},
+		},
+		{
+			name: "lists appended",
+			dst:  map[string]interface{}{"l": []interface{}{1, 2}},
+			src:  map[string]interface{}{"l": []interface{}{2, 3}},
+			opts: MergeOptions{Lists: ListAppend},
+			want: map[string]interface{}{"l": []interface{}{1, 2, 2, 3}},
+		},
+		{
+			name: "lists appended with keep",
+			dst:  map[string]interface{}{"a": 1, "l": []interface{}{1}},
+			src:  map[string]interface{}{"a": 2, "l": []interface{}{2}},
+			opts: MergeOptions{Strategy: StrategyKeep, Lists: ListAppend},
+			want: map[string]interface{}{"a": 1, "l": []interface{}{1, 2}},
+		},
+		{
+			name: "keep overridden",
+			dst:  map[string]interface{}{"a": 1, "l": []interface{}{1}},
+			src:  map[string]interface{}{"a": 2, "l": []interface{}{2}},
+			opts: MergeOptions{Strategy: StrategyKeep, Lists: ListAppend}.With(MergeOptions{Strategy: StrategyOverride}),
+			want: map[string]interface{}{"a": 2, "l": []interface{}{1, 2}},
+		},
+		{
+			name: "lists merged by key",
+			dst: map[string]interface{}{"l": []interface{}{
+				map[string]interface{}{"name": "a", "v": 1, "x": 1},
+				map[string]interface{}{"name": "b", "v": 1},
+				"s",
+			}},
+			src: map[string]interface{}{"l": []interface{}{
+				map[string]interface{}{"name": "a", "v": 2},
+				map[string]interface{}{"name": "c", "v": 2},
+				"s",
+				"t",
+			}},
+			opts: MergeOptions{Lists: ListMerge},
+			want: map[string]interface{}{"l": []interface{}{
+				map[string]interface{}{"name": "a", "v": 2, "x": 1},
+				map[string]interface{}{"name": "b", "v": 1},
+				"s",
+				map[string]interface{}{"name": "c", "v": 2},
+				"t",
+			}},
+		},
+		{
+			name: "lists merged by custom key",
+			dst:  map[string]interface{}{"l": []interface{}{map[string]interface{}{"id": 1, "v": 1}}},
+			src:  map[string]interface{}{"l": []interface{}{map[string]interface{}{"id": 1, "v": 2}}},
+			opts: MergeOptions{Lists: ListMerge, ListKey: "id"},
+			want: map[string]interface{}{"l": []interface{}{map[string]interface{}{"id": 1, "v": 2}}},
+		},
+		{
+			name: "null deletes",
+			dst:  map[string]interface{}{"a": 1, "db": map[string]interface{}{"host": "h", "port": 1}},
+			src:  map[string]interface{}{"a": nil, "db": map[string]interface{}{"port": nil}},
+			want: map[string]interface{}{"db": map[string]interface{}{"host": "h"}},
+		},
+		{
+			name: "null deletes with keep",
+			dst:  map[string]interface{}{"a": 1},
+			src:  map[string]interface{}{"a": nil},
+			opts: MergeOptions{Strategy: StrategyKeep},
+			want: map[string]interface{}{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Merge(test.dst, test.src, test.opts); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(test.dst, test.want) {
+				t.Errorf("got %v, want %v", test.dst, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeInvalidOptions(t *testing.T) {
+	err := Merge(map[string]interface{}{}, map[string]interface{}{}, MergeOptions{Strategy: "first"})
+	if err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
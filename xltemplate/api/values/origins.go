@@ -0,0 +1,95 @@
+package values
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/copystructure"
+)
+
+// Layer is a variables tree merged into the variables,
+// with the name of its origin, e.g. a file.
+type Layer struct {
+	Origin    string
+	Variables map[string]interface{}
+}
+
+// Origins lists the layers merged into a variables tree,
+// from the lowest to the highest precedence.
+type Origins []Layer
+
+// Add appends a layer. The variables are copied, as
+// merging them can alter them later on.
+func (o *Origins) Add(origin string, variables map[string]interface{}) {
+	copied, err := copystructure.Copy(variables)
+	if err != nil {
+		return
+	}
+	*o = append(*o, Layer{Origin: origin, Variables: copied.(map[string]interface{})})
+}
+
+// Lookup returns the origin of the value at path in the merged
+// variables. For a map, it is every layer defining it. For any other
+// value, it is the layer with the highest precedence holding an equal
+// value. If none is found, the parent path is looked up instead.
+func (o Origins) Lookup(merged map[string]interface{}, path []string) string {
+	for ; len(path) > 0; path = path[:len(path)-1] {
+		value, ok := Get(merged, path)
+		if !ok {
+			continue
+		}
+		_, isMap := value.(map[string]interface{})
+
+		var origins []string
+		for i := len(o) - 1; i >= 0; i-- {
+			layerValue, ok := Get(o[i].Variables, path)
+			if !ok {
+				continue
+			}
+			if isMap {
+				origins = appendUnique(origins, o[i].Origin)
+			} else if reflect.DeepEqual(layerValue, value) {
+				return o[i].Origin
+			}
+		}
+		if len(origins) > 0 {
+			return strings.Join(origins, ", ")
+		}
+	}
+	return ""
+}
+
+// Get returns the value at path in variables, path elements
+// being map keys or list indices.
+func Get(variables map[string]interface{}, path []string) (interface{}, bool) {
+	var node interface{} = variables
+	for _, element := range path {
+		switch typed := node.(type) {
+		case map[string]interface{}:
+			value, ok := typed[element]
+			if !ok {
+				return nil, false
+			}
+			node = value
+		case []interface{}:
+			index, err := strconv.Atoi(element)
+			if err != nil || index < 0 || index >= len(typed) {
+				return nil, false
+			}
+			node = typed[index]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, existing := range list {
+		if existing == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
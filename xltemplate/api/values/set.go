@@ -0,0 +1,261 @@
+// Package values manipulates variables trees, made of nested
+// map[string]interface{} and []interface{} as produced by
+// maputil.CastKeysToStrings.
+package values
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Maximum list index accepted in a path, to avoid allocating
+// huge lists on typos.
+const maxIndex = 65536
+
+// pathElement is either a map key or a list index.
+type pathElement struct {
+	key   string
+	index int
+}
+
+func (e pathElement) isIndex() bool {
+	return e.index >= 0
+}
+
+// ParseSet parses a comma separated list of key=value pairs, as given
+// to --set, and sets the values in dest. Keys are dotted paths into
+// nested maps with optional list indices, e.g. a.b[0].c=value. A value
+// between braces, e.g. a={x,y}, is a list. Values are typed as booleans,
+// integers or null when possible, unless asString is true.
+func ParseSet(s string, dest map[string]interface{}, asString bool) error {
+	return parseAssignments(s, func(path string, value string) error {
+		var typed interface{}
+		if list, ok := parseList(value); ok {
+			items := make([]interface{}, len(list))
+			for i, item := range list {
+				items[i] = typedValue(unescape(item), asString)
+			}
+			typed = items
+		} else {
+			typed = typedValue(unescape(value), asString)
+		}
+		return Set(dest, path, typed)
+	})
+}
+
+// ParseSetFile parses a comma separated list of key=path pairs, as given
+// to --set-file, and sets the content of each file as a string in dest.
+func ParseSetFile(s string, dest map[string]interface{}, readFile func(string) ([]byte, error)) error {
+	return parseAssignments(s, func(path string, filePath string) error {
+		content, err := readFile(unescape(filePath))
+		if err != nil {
+			return fmt.Errorf("failed to read file for key %q: %w", path, err)
+		}
+		return Set(dest, path, string(content))
+	})
+}
+
+// Set sets value at path in dest, creating the intermediate maps and
+// lists as needed. Existing values on the way which are not of the
+// expected kind are replaced. A nil value removes the key, as a null
+// value in the variables files, while list items are set to nil.
+func Set(dest map[string]interface{}, path string, value interface{}) error {
+	elements, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if elements[0].isIndex() {
+		return fmt.Errorf("path %q must start with a key", path)
+	}
+	_, err = setElements(dest, elements, value)
+	return err
+}
+
+func setElements(node interface{}, elements []pathElement, value interface{}) (interface{}, error) {
+	if len(elements) == 0 {
+		return value, nil
+	}
+
+	element := elements[0]
+	if element.isIndex() {
+		list, _ := node.([]interface{})
+		for len(list) <= element.index {
+			list = append(list, nil)
+		}
+		child, err := setElements(list[element.index], elements[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		list[element.index] = child
+		return list, nil
+	}
+
+	m, ok := node.(map[string]interface{})
+	if !ok || m == nil {
+		m = map[string]interface{}{}
+	}
+	if len(elements) == 1 && value == nil {
+		delete(m, element.key)
+		return m, nil
+	}
+	child, err := setElements(m[element.key], elements[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	m[element.key] = child
+	return m, nil
+}
+
+// parsePath splits a path such as a.b[0][1].c into its elements.
+// Dots and brackets can be escaped with a backslash.
+func parsePath(path string) ([]pathElement, error) {
+	var elements []pathElement
+	var key strings.Builder
+	hasKey := false
+
+	flushKey := func() error {
+		if !hasKey {
+			return nil
+		}
+		if key.Len() == 0 {
+			return fmt.Errorf("empty key in path %q", path)
+		}
+		elements = append(elements, pathElement{key: key.String(), index: -1})
+		key.Reset()
+		hasKey = false
+		return nil
+	}
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+			hasKey = true
+		case c == '.':
+			if !hasKey && (len(elements) == 0 || !elements[len(elements)-1].isIndex()) {
+				return nil, fmt.Errorf("empty key in path %q", path)
+			}
+			if err := flushKey(); err != nil {
+				return nil, err
+			}
+		case c == '[':
+			if err := flushKey(); err != nil {
+				return nil, err
+			}
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in path %q", path)
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil || index < 0 || index > maxIndex {
+				return nil, fmt.Errorf("invalid index %q in path %q", path[i+1:i+end], path)
+			}
+			elements = append(elements, pathElement{index: index})
+			i += end
+		default:
+			key.WriteByte(c)
+			hasKey = true
+		}
+	}
+	if err := flushKey(); err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return elements, nil
+}
+
+// parseAssignments splits s on unescaped commas outside of braces,
+// then each assignment on its first unescaped equal sign. Values
+// are passed with their escapes.
+func parseAssignments(s string, assign func(path string, value string) error) error {
+	for _, assignment := range splitUnescaped(s, ',', true) {
+		if assignment == "" {
+			continue
+		}
+		parts := splitUnescaped(assignment, '=', false)
+		if len(parts) < 2 {
+			return fmt.Errorf("key %q has no value", assignment)
+		}
+		path := parts[0]
+		value := strings.Join(parts[1:], "=")
+		if err := assign(path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitUnescaped splits s on sep when it is not escaped by a backslash
+// nor, if braces is true, enclosed in braces. Escapes are preserved.
+func splitUnescaped(s string, sep byte, braces bool) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case braces && c == '{':
+			depth++
+		case braces && c == '}' && depth > 0:
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescape removes the backslashes escaping separators in a value.
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`,=\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseList returns the items of a value written as {a,b,c}.
+func parseList(value string) ([]string, bool) {
+	if len(value) < 2 || value[0] != '{' || value[len(value)-1] != '}' {
+		return nil, false
+	}
+	inner := value[1 : len(value)-1]
+	if inner == "" {
+		return []string{}, true
+	}
+	return splitUnescaped(inner, ',', true), true
+}
+
+// typedValue converts value to a boolean, an integer or nil when it
+// looks like one, following what --set does in Helm.
+func typedValue(value string, asString bool) interface{} {
+	if asString {
+		return value
+	}
+	switch strings.ToLower(value) {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	// Leading zeros are kept as strings, e.g. for zip codes or octal modes.
+	if len(value) > 1 && value[0] == '0' {
+		return value
+	}
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	return value
+}
@@ -0,0 +1,130 @@
+package values
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      string
+		asString bool
+		want     map[string]interface{}
+	}{
+		{
+			name: "typed values",
+			set:  "b=true,f=False,i=42,n=-3,s=text,z=007,e=",
+			want: map[string]interface{}{"b": true, "f": false, "i": 42, "n": -3, "s": "text", "z": "007", "e": ""},
+		},
+		{
+			name:     "strings",
+			set:      "b=true,i=42,n=null",
+			asString: true,
+			want:     map[string]interface{}{"b": "true", "i": "42", "n": "null"},
+		},
+		{
+			name: "dotted paths",
+			set:  "a.b.c=1,a.b.d=2",
+			want: map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": 1, "d": 2}}},
+		},
+		{
+			name: "list indices",
+			set:  "l[1]=x,m[0].k=v,n[0][1]=y",
+			want: map[string]interface{}{
+				"l": []interface{}{nil, "x"},
+				"m": []interface{}{map[string]interface{}{"k": "v"}},
+				"n": []interface{}{[]interface{}{nil, "y"}},
+			},
+		},
+		{
+			name: "escapes",
+			set:  `a\.b=1,c=x\,y,d=k\=v,e[0]=\[`,
+			want: map[string]interface{}{"a.b": 1, "c": "x,y", "d": "k=v", "e": []interface{}{`\[`}},
+		},
+		{
+			name: "values with equal signs",
+			set:  "url=http://h/?ref=main",
+			want: map[string]interface{}{"url": "http://h/?ref=main"},
+		},
+		{
+			name: "lists",
+			set:  "l={a,1,true},e={}",
+			want: map[string]interface{}{"l": []interface{}{"a", 1, true}, "e": []interface{}{}},
+		},
+		{
+			name:     "string lists",
+			set:      "l={a,1}",
+			asString: true,
+			want:     map[string]interface{}{"l": []interface{}{"a", "1"}},
+		},
+		{
+			name: "last value wins",
+			set:  "a=1,a.b=2",
+			want: map[string]interface{}{"a": map[string]interface{}{"b": 2}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dest := map[string]interface{}{}
+			if err := ParseSet(test.set, dest, test.asString); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dest, test.want) {
+				t.Errorf("got %v, want %v", dest, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSetErrors(t *testing.T) {
+	for _, set := range []string{"a", "=1", "a..b=1", "[0]=1", "a[x]=1", "a[-1]=1", "a[65537]=1", "a[0=1"} {
+		t.Run(set, func(t *testing.T) {
+			if err := ParseSet(set, map[string]interface{}{}, false); err == nil {
+				t.Errorf("expected an error for %q", set)
+			}
+		})
+	}
+}
+
+func TestParseSetNull(t *testing.T) {
+	dest := map[string]interface{}{
+		"a":  1,
+		"db": map[string]interface{}{"host": "h", "port": 1},
+		"l":  []interface{}{1, 2},
+	}
+	if err := ParseSet("a=null,db.port=null,l[1]=null", dest, false); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"db": map[string]interface{}{"host": "h"},
+		"l":  []interface{}{1, nil},
+	}
+	if !reflect.DeepEqual(dest, want) {
+		t.Errorf("got %v, want %v", dest, want)
+	}
+}
+
+func TestParseSetFile(t *testing.T) {
+	files := map[string]string{"ca.pem": "PEM", "a,b.txt": "true"}
+	readFile := func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return []byte(content), nil
+	}
+
+	dest := map[string]interface{}{}
+	if err := ParseSetFile(`tls.ca=ca.pem,flag=a\,b.txt`, dest, readFile); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"tls": map[string]interface{}{"ca": "PEM"}, "flag": "true"}
+	if !reflect.DeepEqual(dest, want) {
+		t.Errorf("got %v, want %v", dest, want)
+	}
+	if err := ParseSetFile("a=missing", dest, readFile); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
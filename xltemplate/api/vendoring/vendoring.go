@@ -0,0 +1,359 @@
+// Package vendoring copies the remote references of a build into a
+// local vendor directory, and serves them from it in offline mode.
+package vendoring
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"do3b/xltemplate/api/git"
+	"do3b/xltemplate/api/utils"
+
+	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const (
+	// DirName is the name of the directory holding the vendor
+	// directories of the xltemplate files next to it.
+	DirName = "vendor"
+	// indexFileName is the name of the index of the vendor directory.
+	indexFileName = "modules.yaml"
+
+	gitDir  = "git"
+	httpDir = "http"
+)
+
+// index maps the remote references to their copy in the vendor directory.
+type index struct {
+	// Git maps a repository and ref, e.g. https://github.com/org/repo?ref=main,
+	// to the commit it was resolved to.
+	Git map[string]string `yaml:"git,omitempty"`
+	// HTTP maps a URL to the path of its copy, relative to the vendor directory.
+	HTTP map[string]string `yaml:"http,omitempty"`
+}
+
+// Vendor is a directory holding a copy of the git repositories, without
+// their history, and of the HTTP files referenced by a build.
+type Vendor struct {
+	dir string
+	// root holds the copies: dir, or the staging directory
+	// of a vendor being created, until it is saved.
+	root  string
+	index index
+	mu    sync.Mutex
+	// complete holds the copies of entire clones, other
+	// copies may lack files out of sparse checkouts.
+	complete map[string]bool
+}
+
+// DirOf returns the vendor directory of an xltemplate file, named after
+// it, so that xltemplate files of the same directory are vendored apart,
+// e.g. vendor/xltemplate for xltemplate.yaml.
+func DirOf(xltemplateFile string) string {
+	name := filepath.Base(xltemplateFile)
+	return filepath.Join(filepath.Dir(xltemplateFile), DirName, strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// Load reads the index of the vendor directory. A missing
+// directory yields an empty vendor.
+func Load(dir string) (*Vendor, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	v := &Vendor{dir: dir, root: dir}
+	data, err := os.ReadFile(filepath.Join(dir, indexFileName))
+	if os.IsNotExist(err) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vendor index: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &v.index); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vendor index %s: %w", filepath.Join(dir, indexFileName), err)
+	}
+	return v, nil
+}
+
+// Create returns an empty vendor, recording the references in a staging
+// directory next to the vendor directory. Save replaces the content of
+// the vendor directory with them, so that it is left unchanged if the
+// recording fails.
+func Create(dir string) (*Vendor, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create vendor directory: %w", err)
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vendor staging directory: %w", err)
+	}
+	return &Vendor{dir: dir, root: staging}, nil
+}
+
+// Dir returns the vendor directory.
+func (v *Vendor) Dir() string {
+	return v.dir
+}
+
+// Save writes the index of the vendor directory. A created vendor
+// then replaces the references of the vendor directory, the
+// other files of the directory are kept.
+func (v *Vendor) Save() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	data, err := yaml.Marshal(v.index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(v.root, indexFileName), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write vendor index: %w", err)
+	}
+	if v.root == v.dir {
+		return nil
+	}
+
+	if err := os.MkdirAll(v.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create vendor directory: %w", err)
+	}
+	// The previous index is removed first and the new one moved last,
+	// so that an index never references missing copies.
+	if err := os.RemoveAll(filepath.Join(v.dir, indexFileName)); err != nil {
+		return err
+	}
+	for _, name := range []string{gitDir, httpDir, indexFileName} {
+		if err := os.RemoveAll(filepath.Join(v.dir, name)); err != nil {
+			return err
+		}
+		staged := filepath.Join(v.root, name)
+		if _, err := os.Stat(staged); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(staged, filepath.Join(v.dir, name)); err != nil {
+			return fmt.Errorf("failed to move vendored references: %w", err)
+		}
+	}
+	if err := os.RemoveAll(v.root); err != nil {
+		return err
+	}
+	v.root = v.dir
+	return nil
+}
+
+// Cleanup removes the staging directory of a created
+// vendor, if it was not saved.
+func (v *Vendor) Cleanup() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.root == v.dir {
+		return nil
+	}
+	return os.RemoveAll(v.root)
+}
+
+// Recorder returns a cloner cloning with next, then copying
+// the clone into the vendor directory.
+func (v *Vendor) Recorder(next git.Cloner) git.Cloner {
+	return func(repoSpec *git.RepoSpec) error {
+		key := repoSpec.RefURL()
+		if err := next(repoSpec); err != nil {
+			return err
+		}
+		commit := repoSpec.Commit
+		if commit == "" {
+			var err error
+			if commit, err = git.HeadCommit(repoSpec); err != nil {
+				return err
+			}
+		}
+
+		// Copies are serialized, as different refs can
+		// point to the same commit. Clones limited to a
+		// directory are merged into the copy of the commit.
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		dir := v.gitDir(repoSpec, commit)
+		if !v.complete[dir] {
+			if err := copyDir(repoSpec.Dir.String(), dir); err != nil {
+				return fmt.Errorf("failed to vendor %s: %w", key, err)
+			}
+			if repoSpec.SparseDir == "" {
+				if v.complete == nil {
+					v.complete = map[string]bool{}
+				}
+				v.complete[dir] = true
+			}
+		}
+		if v.index.Git == nil {
+			v.index.Git = map[string]string{}
+		}
+		v.index.Git[key] = commit
+		return nil
+	}
+}
+
+// Cloner returns a cloner pointing the repositories to their copy in the
+// vendor directory, without running git. The ref must be a vendored ref
+// or commit.
+func (v *Vendor) Cloner() git.Cloner {
+	return func(repoSpec *git.RepoSpec) error {
+		v.mu.Lock()
+		commit, ok := v.index.Git[repoSpec.RefURL()]
+		v.mu.Unlock()
+		if !ok {
+			// The ref may be the commit, e.g. when locked.
+			commit = repoSpec.Ref
+		}
+		if commit == "" {
+			return v.notVendoredError(repoSpec.RefURL())
+		}
+		dir := v.gitDir(repoSpec, commit)
+		if _, err := os.Stat(dir); err != nil {
+			return v.notVendoredError(repoSpec.RefURL())
+		}
+		repoSpec.Dir = filesys.ConfirmedDir(dir)
+		repoSpec.Commit = commit
+		repoSpec.Persistent = true
+		return nil
+	}
+}
+
+// RecordingHTTPClient returns a client copying the fetched
+// files into the vendor directory.
+func (v *Vendor) RecordingHTTPClient(next *http.Client) *http.Client {
+	return utils.BodyClient(next, func(req *http.Request, body []byte) error {
+		return v.recordHTTP(req.URL, body)
+	})
+}
+
+// HTTPClient returns a client serving the files from
+// the vendor directory, without network access.
+func (v *Vendor) HTTPClient() *http.Client {
+	return &http.Client{Transport: &offlineTransport{vendor: v}}
+}
+
+func (v *Vendor) recordHTTP(u *url.URL, body []byte) error {
+	rel := httpPath(u)
+	file := filepath.Join(v.root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("failed to vendor %s: %w", u, err)
+	}
+	if err := os.WriteFile(file, body, 0o644); err != nil {
+		return fmt.Errorf("failed to vendor %s: %w", u, err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.index.HTTP == nil {
+		v.index.HTTP = map[string]string{}
+	}
+	v.index.HTTP[u.String()] = rel
+	return nil
+}
+
+type offlineTransport struct {
+	vendor *Vendor
+}
+
+func (t *offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.vendor.mu.Lock()
+	rel, ok := t.vendor.index.HTTP[req.URL.String()]
+	t.vendor.mu.Unlock()
+	if !ok {
+		return nil, t.vendor.notVendoredError(req.URL.String())
+	}
+	body, err := os.ReadFile(filepath.Join(t.vendor.root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (v *Vendor) notVendoredError(reference string) error {
+	return fmt.Errorf("%s is not vendored in %s, run 'xltemplate vendor' with network access", reference, v.dir)
+}
+
+// gitDir returns the directory of the copy of a repository at a commit.
+func (v *Vendor) gitDir(repoSpec *git.RepoSpec, commit string) string {
+	return filepath.Join(v.root, gitDir, repoSpec.LocalPath(), commit)
+}
+
+// httpPath returns the path of the copy of an HTTP file,
+// relative to the vendor directory.
+func httpPath(u *url.URL) string {
+	elements := []string{httpDir, git.SanitizeName(u.Host)}
+	for _, element := range strings.Split(strings.Trim(path.Clean(u.Path), "/"), "/") {
+		if element != "" {
+			elements = append(elements, git.SanitizeName(element))
+		}
+	}
+	if u.RawQuery != "" {
+		elements[len(elements)-1] += "_" + git.SanitizeName(u.RawQuery)
+	}
+	return path.Join(elements...)
+}
+
+// copyDir copies the files of a clone, without the .git
+// directories and files of the repository and its submodules.
+func copyDir(src string, dst string) error {
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			// The link may come from a previous copy of the commit.
+			_ = os.Remove(target)
+			return os.Symlink(link, target)
+		default:
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+	})
+}
@@ -1,25 +1,94 @@
 package build
 
 import (
+	"do3b/xltemplate/api/auth"
+	"do3b/xltemplate/api/git"
 	"do3b/xltemplate/api/loader"
+	"do3b/xltemplate/api/lock"
+	"do3b/xltemplate/api/retry"
+	"do3b/xltemplate/api/schema"
 	"do3b/xltemplate/api/templateengine"
+	"do3b/xltemplate/api/values"
+	"do3b/xltemplate/api/vendoring"
 	"fmt"
 	"io"
-	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/imdario/mergo"
-	"github.com/roboll/helmfile/pkg/maputil"
+	"github.com/mitchellh/copystructure"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
 type buildFlags struct {
-	Variables string
+	Variables variablesRef
 	Source    string
-	Patterns  []string
+	Patterns  []patternRef
+	Output    string
+	Strict    bool
+	Targets   []buildTarget
+	Target    string `yaml:"-"`
+	Env       envConfig
+	Merge     values.MergeOptions
+	Schema    string
+	NoCache   bool `yaml:"noCache"`
+	Frozen    bool `yaml:"-"`
+	Offline   bool `yaml:"-"`
+	Jobs      int  `yaml:"jobs"`
+	// DuplicateTemplates tells what happens when a template is defined
+	// more than once, templateengine.DuplicateWarn if empty.
+	DuplicateTemplates templateengine.DuplicatePolicy `yaml:"duplicateTemplates"`
+	// GitBackend is the implementation of git, git.BackendExec or git.BackendBuiltin.
+	GitBackend string `yaml:"gitBackend"`
+	// Credentials is the credentials file of the private hosts,
+	// auth.DefaultPath() if empty.
+	Credentials string `yaml:"-"`
+	// Retries is the number of retries of the remote fetches failing
+	// with a transient error, retry.DefaultPolicy if nil.
+	Retries      *int          `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retryBackoff"`
+
+	// Command line overrides of the variables.
+	SetValues       []string `yaml:"-"`
+	SetStringValues []string `yaml:"-"`
+	SetFileValues   []string `yaml:"-"`
+
+	// lockFile is the lock file of the xltemplate file, if any.
+	lockFile string
+	// vendorDir is the vendor directory of the xltemplate file, if any.
+	vendorDir string
+	// resolveOnly loads the remote references without rendering the targets.
+	resolveOnly bool
+}
+
+// envConfig maps environment variables starting with Prefix, and the
+// entries of the .env Files, into the variables.
+type envConfig struct {
+	Prefix string
+	Files  []string
+}
+
+// buildContext holds what is loaded once and shared by every target.
+type buildContext struct {
+	patterns  []templateengine.Library
+	files     *templateengine.Files
+	variables map[string]interface{}
+	origins   values.Origins
+	schema    *schema.Schema
+	remote    loader.RemoteOptions
+}
+
+// buildTarget is a source rendered to an output, with optional
+// variables merged on top of the shared ones.
+type buildTarget struct {
+	Name      string
+	Source    string
+	Variables variablesRef
 	Output    string
 }
 
@@ -34,194 +103,466 @@ func NewCmdVersion(fileSystem filesys.FileSystem, w io.Writer) *cobra.Command {
 		Example:      `xltemplate build xltemplate.yaml`,
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			xltemplateFile := buildFlags{}
-			if len(args) > 0 && args[0] != "" {
-				file, err := os.Open(args[0])
-				if err != nil {
-					fmt.Println(err)
-				}
-				defer file.Close()
-				fileinfo, err := file.Stat()
-				if err != nil {
-					fmt.Println(err)
-				}
-				filesize := fileinfo.Size()
-				buffer := make([]byte, filesize)
-				file.Read(buffer)
-
-				yaml.Unmarshal(buffer, &xltemplateFile)
-				slog.Debug("Xltemplate file content", "xltemplateFile", xltemplateFile)
+			xltemplateFile := ""
+			if len(args) > 0 {
+				xltemplateFile = args[0]
 			}
-
-			// Merging the content of the xltemplate file with the command line arguments
-			if err := mergo.Merge(&opts, xltemplateFile, mergo.WithAppendSlice); err != nil {
-				slog.Error("Error merging xltemplate file with command line arguments", "error", err)
+			if err := mergeXltemplateFile(&opts, xltemplateFile); err != nil {
+				return err
 			}
-
 			slog.Debug("Executing build command with options", "opts", opts)
 			return Run(opts, fileSystem, w)
 		},
 	}
 
-	cmd.Flags().StringVar(&opts.Variables, "variables", "", "variables YAML file")
+	cmd.Flags().Var(&opts.Variables, "variables", "variables file (YAML, JSON, TOML or HCL)")
+	cmd.Flags().StringVar(&opts.Variables.Format, "variables-format", "", "format of the variables file (optional - guessed from the file extension otherwise)")
 	cmd.Flags().StringVar(&opts.Source, "source", "", "source file path to parse")
-	cmd.Flags().StringArrayVar(&opts.Patterns, "patterns", []string{}, "path to patterns directory")
+	cmd.Flags().Var(patternsFlag{&opts.Patterns}, "patterns", "path to patterns directory, or alias=path to namespace its templates as alias/name")
 	cmd.Flags().StringVar(&opts.Output, "output", "", "output file path (optional - writes to standard output otherwise)")
+	cmd.Flags().StringVar(&opts.Target, "target", "", "name of the single target to build (optional - builds all targets otherwise)")
+	cmd.Flags().StringVar(&opts.Env.Prefix, "env-prefix", "", "map environment variables starting with this prefix into the variables, e.g. XLT_VAR_db__host to .db.host")
+	cmd.Flags().StringArrayVar(&opts.Env.Files, "env-file", []string{}, "path to a .env file mapped into the variables (filtered by the env prefix if any)")
+	cmd.Flags().StringArrayVar(&opts.SetValues, "set", []string{}, "set variables on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	cmd.Flags().StringArrayVar(&opts.SetStringValues, "set-string", []string{}, "set STRING variables on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	cmd.Flags().StringArrayVar(&opts.SetFileValues, "set-file", []string{}, "set variables from the content of files (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	cmd.Flags().StringVar(&opts.Schema, "schema", "", "JSON Schema the variables are validated against (optional)")
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, "fail the build on missing variables instead of rendering <no value>")
+	cmd.Flags().StringVar((*string)(&opts.DuplicateTemplates), "duplicate-templates", "", "what to do when a template is defined more than once: warn or error (default warn)")
+	addRemoteFlags(&cmd, &opts)
+	cmd.Flags().IntVarP(&opts.Jobs, "jobs", "j", 0, "maximum number of patterns fetched concurrently (default: number of CPUs)")
+	cmd.Flags().BoolVar(&opts.Offline, "offline", false, "load the remote references from the vendor directory, without network access")
+	cmd.Flags().BoolVar(&opts.Frozen, "frozen", false, "fail if the lock file is missing, does not lock every remote reference or locks references no longer used, instead of updating it")
 	return &cmd
 }
 
+// mergeXltemplateFile merges the content of the xltemplate file, if
+// any, into the command line options, and locates its lock file.
+func mergeXltemplateFile(opts *buildFlags, filePath string) error {
+	if filePath == "" {
+		return nil
+	}
+	xltemplateFile, err := loadXltemplateFile(filePath)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Xltemplate file content", "xltemplateFile", xltemplateFile)
+
+	// Merging the content of the xltemplate file with the command line arguments,
+	// keeping the pointers set on the command line even if they point to zero.
+	if err := mergo.Merge(opts, xltemplateFile, mergo.WithAppendSlice, mergo.WithoutDereference); err != nil {
+		slog.Error("Error merging xltemplate file with command line arguments", "error", err)
+	}
+	opts.lockFile = lock.PathOf(filePath)
+	opts.vendorDir = vendoring.DirOf(filePath)
+	return nil
+}
+
 func Run(opts buildFlags, fileSystem filesys.FileSystem, w io.Writer) error {
-	var err error
+	buildLock, err := loadLock(opts)
+	if err != nil {
+		return err
+	}
+	remote, err := newRemoteOptions(opts, buildLock)
+	if err != nil {
+		return err
+	}
+	if err := run(opts, remote, fileSystem, w); err != nil {
+		return err
+	}
+	return saveLock(opts, buildLock)
+}
 
-	patterns := []string{}
-	pattern_loaders := []loader.FileLoader{}
-	for _, pattern := range opts.Patterns {
-		pattern_loader, err := loader.NewLoader(
-			loader.RestrictionNone,
-			pattern,
-			fileSystem,
-		)
-		if err != nil {
-			slog.Error("Error loading patterns", "error", err)
-			return err
+// saveLock writes the lock after a build, without the references no
+// longer used when every target was built. A frozen lock is not
+// written, and fails if it has references no longer used.
+func saveLock(opts buildFlags, buildLock *lock.Lock) error {
+	if buildLock == nil {
+		return nil
+	}
+	if opts.Target == "" {
+		if opts.Frozen {
+			return buildLock.UnusedError()
 		}
-
-		patterns = append(patterns, readPatternDirectory(pattern_loader.Root())...)
-		pattern_loaders = append(pattern_loaders, *pattern_loader)
+		buildLock.ForgetUnused()
 	}
+	if opts.Frozen {
+		return nil
+	}
+	return buildLock.Save()
+}
 
-	variables := map[string]interface{}{}
-	if opts.Variables != "" {
-		variables, err = loadYamlFromFile(opts.Variables)
-		if err != nil {
-			slog.Error("Error loading variables", "error", err)
-			return err
+// loadLock loads the lock file of the xltemplate file. There is
+// no lock without xltemplate file.
+func loadLock(opts buildFlags) (*lock.Lock, error) {
+	if opts.lockFile == "" {
+		if opts.Frozen {
+			return nil, fmt.Errorf("--frozen requires an xltemplate file")
 		}
+		return nil, nil
+	}
+	buildLock, err := lock.Load(opts.lockFile)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Frozen && !buildLock.Exists() {
+		return nil, fmt.Errorf("lock file %s is missing, run 'xltemplate lock update' to create it", buildLock.Path())
+	}
+	buildLock.Frozen = opts.Frozen
+	return buildLock, nil
+}
 
-		if variable, exists := variables[":includes"]; exists {
-			// Convert decoded yaml value so nested map are all map[string]{interface} instead of map[interface{}]interface{}
-			slog.Debug("Includes found in variables", ":includes", variable)
-			var includedVariables []map[string]interface{}
-			if list, ok := variable.([]interface{}); ok {
-				for _, item := range list {
-					includedVariable, err := loadYamlFromFile(item.(string))
-					includedVariables = append(includedVariables, includedVariable)
-					if err != nil {
-						slog.Error("Error loading included variables", "error", err)
-						return err
-					}
-				}
-			} else {
-				slog.Error("Includes must be a list", "includes", variable)
-				panic(":includes must be a list")
-			}
+func run(opts buildFlags, remote loader.RemoteOptions, fileSystem filesys.FileSystem, w io.Writer) error {
+	targets, err := selectTargets(opts)
+	if err != nil {
+		return err
+	}
+	if err := opts.Merge.Validate(); err != nil {
+		return err
+	}
+	if err := opts.DuplicateTemplates.Validate(); err != nil {
+		return err
+	}
 
-			for _, includedVariable := range includedVariables {
-				if err := mergo.Merge(&variables, includedVariable); err != nil {
-					slog.Error("Error merging included variables", "error", err)
-				}
-			}
+	// References to the same repository and ref share a clone,
+	// removed once every loader using it is cleaned up.
+	clones := git.NewSharedClones(remote.Cloner)
+	defer clones.Close()
+	remote.Cloner = clones.Cloner()
 
-			delete(variables, ":includes")
-			slog.Debug("Merged variables", "variables", variables)
+	// Patterns are loaded once and shared by every target.
+	shared := buildContext{patterns: []templateengine.Library{}, files: templateengine.NewFiles(), remote: remote}
+	defaults := map[string]interface{}{}
+	pattern_loaders, err := loadPatterns(opts.Patterns, opts.Jobs, fileSystem, shared.remote)
+	for _, pattern_loader := range pattern_loaders {
+		if pattern_loader != nil {
+			defer pattern_loader.Cleanup()
 		}
 	}
+	if err != nil {
+		slog.Error("Error loading patterns", "error", err)
+		return err
+	}
+	// Patterns are read in the configured order, whatever order they were fetched in.
+	for i, pattern := range opts.Patterns {
+		pattern_loader := pattern_loaders[i]
+		shared.patterns = append(shared.patterns, templateengine.Library{
+			Namespace: pattern.As,
+			Source:    pattern.Source,
+			Root:      pattern_loader.Root(),
+			Files:     readPatternDirectory(pattern_loader.Root()),
+		})
+		// Files of later patterns replace the ones of earlier patterns.
+		if err := loadPatternFiles(shared.files, pattern, pattern_loader, fileSystem); err != nil {
+			return fmt.Errorf("failed to load files of pattern %s: %w", pattern.Source, err)
+		}
 
-	source := ""
-	if opts.Source != "" {
-		source_loader, err := loader.NewLoader(
-			loader.RestrictionNone,
-			opts.Source,
-			fileSystem,
-		)
+		// Defaults of later patterns override the ones of earlier patterns.
+		patternDefaults, err := loadPatternDefaults(pattern_loader, fileSystem)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to load defaults of pattern %s: %w", pattern.Source, err)
 		}
-		b, err := source_loader.Load(source_loader.FilePath)
-		if err != nil {
+		shared.origins.Add("defaults of pattern "+pattern.Source, patternDefaults)
+		if err := values.Merge(defaults, patternDefaults, overriding(opts.Merge)); err != nil {
 			return err
 		}
-		source = string(b)
 	}
 
-	templateEngine := templateengine.NewTemplateEngine(opts.Source, variables, source, patterns)
-	result, err := templateEngine.Parse()
-	for _, pattern_loader := range pattern_loaders {
-		pattern_loader.Cleanup()
+	variables, err := loadVariables(opts.Variables, opts.Merge, &shared.origins, fileSystem, shared.remote)
+	if err != nil {
+		return err
+	}
+	// User variables always override the pattern defaults.
+	if err := values.Merge(defaults, variables, overriding(opts.Merge)); err != nil {
+		return err
 	}
+	shared.variables = defaults
+	envVariables, err := loadEnvVariables(opts.Env, fileSystem)
 	if err != nil {
 		return err
 	}
+	shared.origins.Add("environment", envVariables)
+	if err := values.Merge(shared.variables, envVariables, overriding(opts.Merge)); err != nil {
+		slog.Error("Error merging environment variables", "error", err)
+		return err
+	}
 
-	var output = os.Stdout
-	if opts.Output != "" {
-		slog.Info("Writing to file", "file", opts.Output)
-		newFile, err := os.Create(opts.Output)
+	if opts.Schema != "" {
+		shared.schema, err = schema.Load(opts.Schema, fileSystem, shared.remote)
 		if err != nil {
 			return err
 		}
-		output = newFile
 	}
 
-	output.Write([]byte(result))
+	for _, target := range targets {
+		slog.Debug("Building target", "target", target)
+		if err := runTarget(opts, target, shared, fileSystem, w); err != nil {
+			if target.Name != "" {
+				return fmt.Errorf("target %q: %w", target.Name, err)
+			}
+			return err
+		}
+	}
 
 	return nil
 }
 
-func recursivelyReadPatternDirectory(path string, dirEntry fs.DirEntry, patterns []string) []string {
-	fileInfo, err := dirEntry.Info()
+// newRemoteOptions returns how remote references are fetched: from the
+// vendor directory when offline, otherwise git repositories are cloned by
+// the git backend in the cache unless disabled, or in temporary
+// directories, with the credentials of the hosts. The lock, if any, pins
+// them.
+func newRemoteOptions(opts buildFlags, buildLock *lock.Lock) (loader.RemoteOptions, error) {
+	var credentials *auth.Credentials
+	if !opts.Offline {
+		var err error
+		if credentials, err = loadCredentials(opts.Credentials); err != nil {
+			return loader.RemoteOptions{}, err
+		}
+	}
+	backend, err := git.NewBackend(opts.GitBackend, credentials)
 	if err != nil {
-		slog.Error("Error getting file info", "error", err)
+		return loader.RemoteOptions{}, err
+	}
+	policy := opts.retryPolicy()
+	backend = git.WithRetries(backend, policy)
+	remote := loader.RemoteOptions{Cloner: git.ClonerUsing(backend), HTTPClient: credentials.HTTPClient(nil), Retry: policy}
+	if opts.Offline {
+		vendor, err := vendoring.Load(opts.vendorDirectory())
+		if err != nil {
+			return remote, err
+		}
+		remote.Cloner = vendor.Cloner()
+		remote.HTTPClient = vendor.HTTPClient()
+	} else if !opts.NoCache {
+		cacheDir, err := git.DefaultCacheDir()
+		if err != nil {
+			return remote, fmt.Errorf("failed to locate cache directory: %w", err)
+		}
+		cache := git.NewCache(cacheDir)
+		cache.Backend = backend
+		remote.Cloner = cache.Cloner()
+	}
+	if buildLock != nil {
+		remote.Cloner = buildLock.Cloner(remote.Cloner)
+		remote.HTTPClient = buildLock.HTTPClient(remote.HTTPClient)
 	}
+	return remote, nil
+}
+
+// addRemoteFlags adds the flags of the fetches of the remote references,
+// shared by the commands loading them.
+func addRemoteFlags(cmd *cobra.Command, opts *buildFlags) {
+	cmd.Flags().BoolVar(&opts.NoCache, "no-cache", false, "clone git repositories in temporary directories instead of the cache")
+	cmd.Flags().StringVar(&opts.GitBackend, "git-backend", "", "git implementation: exec runs the git binary, builtin needs no git install (default exec)")
+	cmd.Flags().StringVar(&opts.Credentials, "credentials", "", "credentials file of the private git and HTTP hosts (default $XDG_CONFIG_HOME/xltemplate/credentials.yaml)")
+	cmd.Flags().Var(optionalInt{&opts.Retries}, "retries", "number of retries of the remote fetches failing with a transient error, e.g. a timeout or a 5xx (default 2)")
+	cmd.Flags().DurationVar(&opts.RetryBackoff, "retry-backoff", 0, "delay before the first retry, doubled for each next one (default 1s)")
+}
 
-	if fileInfo.IsDir() {
-		return append(patterns, readPatternDirectory(path+"/"+fileInfo.Name())...)
-	} else {
-		return append(patterns, path+"/"+fileInfo.Name())
+// retryPolicy returns the retry policy of the remote fetches.
+func (opts buildFlags) retryPolicy() retry.Policy {
+	policy := retry.DefaultPolicy
+	if opts.Retries != nil {
+		policy.Attempts = *opts.Retries + 1
 	}
+	if opts.RetryBackoff > 0 {
+		policy.Backoff = opts.RetryBackoff
+	}
+	return policy
 }
 
-func readPatternDirectory(path string) []string {
-	patternFolder, err := os.Open(path)
-	if err != nil {
-		slog.Error("Error opening pattern directory", "error", err)
+// optionalInt is a flag setting an int pointer, left
+// nil when the flag is not set.
+type optionalInt struct {
+	value **int
+}
+
+func (o optionalInt) String() string {
+	if o.value == nil || *o.value == nil {
+		return ""
 	}
-	defer patternFolder.Close()
+	return strconv.Itoa(**o.value)
+}
 
-	patterns, err := patternFolder.ReadDir(-1)
+func (o optionalInt) Set(s string) error {
+	i, err := strconv.Atoi(s)
 	if err != nil {
-		slog.Error("Error reading pattern directory", "error", err)
+		return err
 	}
+	*o.value = &i
+	return nil
+}
+
+func (o optionalInt) Type() string {
+	return "int"
+}
 
-	var parsedFiles []string
-	for _, pattern := range patterns {
-		parsedFiles = recursivelyReadPatternDirectory(path, pattern, parsedFiles)
+// loadCredentials loads the credentials file at path,
+// or at the default path if empty.
+func loadCredentials(path string) (*auth.Credentials, error) {
+	if path == "" {
+		var err error
+		if path, err = auth.DefaultPath(); err != nil {
+			return nil, fmt.Errorf("failed to locate credentials file: %w", err)
+		}
+	} else if _, err := os.Stat(path); err != nil {
+		// An explicit file must exist.
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
 	}
+	return auth.Load(path)
+}
 
-	return parsedFiles
+// vendorDirectory returns the vendor directory of the
+// xltemplate file, or in the current directory without one.
+func (opts buildFlags) vendorDirectory() string {
+	if opts.vendorDir == "" {
+		return vendoring.DirName
+	}
+	return opts.vendorDir
 }
 
-func loadYamlFromFile(filePath string) (map[string]interface{}, error) {
-	file, err := os.Open(filePath)
+// selectTargets returns the targets to render. The top-level source and
+// output form an unnamed target, rendered when no targets are listed or
+// when a source is given. A single target can be selected by name.
+func selectTargets(opts buildFlags) ([]buildTarget, error) {
+	targets := opts.Targets
+	if opts.Source != "" || len(targets) == 0 {
+		targets = append([]buildTarget{{Source: opts.Source, Output: opts.Output}}, targets...)
+	}
+	if opts.Target == "" {
+		return targets, nil
+	}
+
+	for _, target := range targets {
+		if target.Name == opts.Target {
+			return []buildTarget{target}, nil
+		}
+	}
+	return nil, fmt.Errorf("target %q not found", opts.Target)
+}
+
+// runTarget renders the source of a target with the shared variables,
+// overridden by the target variables, and writes it to the target output.
+func runTarget(
+	opts buildFlags, target buildTarget, shared buildContext,
+	fileSystem filesys.FileSystem, w io.Writer) error {
+	origins := append(values.Origins{}, shared.origins...)
+	targetVariables, err := loadVariables(target.Variables, opts.Merge, &origins, fileSystem, shared.remote)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return err
+	}
+	// Copy the shared variables so targets cannot alter each other.
+	sharedVariables, err := copystructure.Copy(shared.variables)
+	if err != nil {
+		return err
+	}
+	variables := sharedVariables.(map[string]interface{})
+	if err := values.Merge(variables, targetVariables, overriding(opts.Merge)); err != nil {
+		slog.Error("Error merging target variables", "error", err)
+		return err
+	}
+	if err := applySetValues(opts, variables); err != nil {
+		return err
+	}
+
+	if shared.schema != nil && !opts.resolveOnly {
+		// The command line values are applied alone to find out their origin.
+		commandLineVariables := map[string]interface{}{}
+		if err := applySetValues(opts, commandLineVariables); err != nil {
+			return err
+		}
+		origins.Add("command line", commandLineVariables)
+		if err := shared.schema.Validate(variables, origins); err != nil {
+			return err
+		}
 	}
-	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	source := ""
+	if target.Source != "" {
+		source_loader, err := loader.NewLoaderWithOptions(
+			loader.RestrictionNone,
+			target.Source,
+			fileSystem,
+			shared.remote,
+		)
+		if err != nil {
+			return err
+		}
+		defer source_loader.Cleanup()
+		b, err := source_loader.Load(source_loader.FilePath)
+		if err != nil {
+			return err
+		}
+		source = string(b)
+	}
+	if opts.resolveOnly {
+		return nil
+	}
+
+	templateEngine := templateengine.NewTemplateEngine(target.Source, variables, source, shared.patterns)
+	templateEngine.Strict = opts.Strict
+	templateEngine.Duplicates = opts.DuplicateTemplates
+	templateEngine.Files = shared.files
+	result, err := templateEngine.Parse()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return err
 	}
 
-	var result map[string]interface{}
-	err = yaml.Unmarshal(data, &result)
+	if target.Output == "" {
+		_, err = w.Write([]byte(result))
+		return err
+	}
+
+	slog.Info("Writing to file", "file", target.Output)
+	output, err := os.Create(target.Output)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+		return err
 	}
+	defer output.Close()
+	_, err = output.Write([]byte(result))
+	return err
+}
 
-	result, err = maputil.CastKeysToStrings(result)
+// loadXltemplateFile reads an xltemplate file and resolves the relative
+// paths it contains against the directory of the file.
+func loadXltemplateFile(filePath string) (buildFlags, error) {
+	xltemplateFile := buildFlags{}
+	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to cast keys to strings: %w", err)
+		return xltemplateFile, fmt.Errorf("failed to read xltemplate file: %w", err)
+	}
+	if err = yaml.Unmarshal(data, &xltemplateFile); err != nil {
+		return xltemplateFile, fmt.Errorf("failed to unmarshal xltemplate file: %w", err)
+	}
+
+	baseDir := filepath.Dir(filePath)
+	xltemplateFile.Source = resolvePath(baseDir, xltemplateFile.Source)
+	xltemplateFile.Variables.File = resolvePath(baseDir, xltemplateFile.Variables.File)
+	xltemplateFile.Output = resolvePath(baseDir, xltemplateFile.Output)
+	xltemplateFile.Schema = resolvePath(baseDir, xltemplateFile.Schema)
+	for i, pattern := range xltemplateFile.Patterns {
+		xltemplateFile.Patterns[i].Source = resolvePath(baseDir, pattern.Source)
+	}
+	for i, file := range xltemplateFile.Env.Files {
+		xltemplateFile.Env.Files[i] = resolvePath(baseDir, file)
+	}
+	for i, target := range xltemplateFile.Targets {
+		xltemplateFile.Targets[i].Source = resolvePath(baseDir, target.Source)
+		xltemplateFile.Targets[i].Variables.File = resolvePath(baseDir, target.Variables.File)
+		xltemplateFile.Targets[i].Output = resolvePath(baseDir, target.Output)
+	}
+	return xltemplateFile, nil
+}
+
+// resolvePath returns path relative to baseDir, unless
+// it is empty, absolute or a remote URL.
+func resolvePath(baseDir string, path string) string {
+	if path == "" || filepath.IsAbs(path) || loader.IsRemoteFile(path) {
+		return path
+	}
+	if _, err := git.NewRepoSpecFromURL(path); err == nil {
+		return path
 	}
-	return result, nil
+	return filepath.Join(baseDir, path)
 }
@@ -0,0 +1,81 @@
+package build
+
+import (
+	"do3b/xltemplate/api/lock"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// NewCmdLock makes a new lock command, managing the lock file
+// pinning the remote references of an xltemplate file.
+func NewCmdLock(fileSystem filesys.FileSystem, w io.Writer) *cobra.Command {
+	lockCmd := cobra.Command{
+		Use:   "lock",
+		Short: "Manage the lock file of remote references",
+		Long: `Manage the lock file of an xltemplate file, e.g. xltemplate.lock for
+xltemplate.yaml, which pins its git references to commits and its HTTP
+files to their content hash.`,
+	}
+	lockCmd.AddCommand(newCmdLockUpdate(fileSystem, w))
+	return &lockCmd
+}
+
+func newCmdLockUpdate(fileSystem filesys.FileSystem, w io.Writer) *cobra.Command {
+	opts := buildFlags{}
+	xltemplateFile := ""
+
+	cmd := cobra.Command{
+		Use:   "update [source]",
+		Short: "Resolve the remote references again and update the lock file",
+		Long: `Resolve the remote references again and update the lock file. Without
+argument, the lock file is rebuilt from scratch. Otherwise only the entries
+of the given git repository or HTTP URL are updated.`,
+		Example: `xltemplate lock update
+xltemplate lock update https://github.com/user/repo?ref=main`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source := ""
+			if len(args) > 0 {
+				source = args[0]
+			}
+			if err := mergeXltemplateFile(&opts, xltemplateFile); err != nil {
+				return err
+			}
+			opts.resolveOnly = true
+			return runLockUpdate(opts, source, fileSystem, w)
+		},
+	}
+
+	cmd.Flags().StringVarP(&xltemplateFile, "file", "f", "xltemplate.yaml", "xltemplate file whose lock file is updated")
+	addRemoteFlags(&cmd, &opts)
+	cmd.Flags().BoolVar(&opts.Offline, "offline", false, "load the remote references from the vendor directory, without network access")
+	return &cmd
+}
+
+// runLockUpdate forgets the locked entries of source, or all of them,
+// then loads every remote reference of the build to lock it again.
+func runLockUpdate(opts buildFlags, source string, fileSystem filesys.FileSystem, w io.Writer) error {
+	buildLock, err := lock.Load(opts.lockFile)
+	if err != nil {
+		return err
+	}
+	if buildLock.Forget(source) == 0 && source != "" {
+		return fmt.Errorf("%s is not locked in %s", source, buildLock.Path())
+	}
+	remote, err := newRemoteOptions(opts, buildLock)
+	if err != nil {
+		return err
+	}
+	if err := run(opts, remote, fileSystem, io.Discard); err != nil {
+		return err
+	}
+	if err := saveLock(opts, buildLock); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Updated %s\n", buildLock.Path())
+	return nil
+}
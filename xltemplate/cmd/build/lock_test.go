@@ -0,0 +1,77 @@
+package build
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// TestLockPerXltemplateFile pins that xltemplate files of the same
+// directory have their own lock, and that --frozen fails on a lock
+// holding references no longer used, which other builds remove.
+func TestLockPerXltemplateFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v: " + strings.TrimPrefix(r.URL.Path, "/") + "\n"))
+	}))
+	defer server.Close()
+	t.Setenv("XLTEMPLATE_CREDENTIALS", filepath.Join(t.TempDir(), "credentials.yaml"))
+
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("source.tmpl", "{{ .v }}")
+	a := write("a.yaml", "source: source.tmpl\nvariables: "+server.URL+"/a\n")
+	b := write("b.yaml", "source: source.tmpl\nvariables: "+server.URL+"/b\n")
+	build := func(file string, frozen bool) (string, error) {
+		opts := buildFlags{NoCache: true, Frozen: frozen}
+		if err := mergeXltemplateFile(&opts, file); err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		err := Run(opts, filesys.MakeFsOnDisk(), &output)
+		return output.String(), err
+	}
+
+	for _, file := range []string{a, b} {
+		if _, err := build(file, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, want := range map[string]string{a: "a", b: "b"} {
+		output, err := build(file, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != want {
+			t.Errorf("got %q, want %q", output, want)
+		}
+	}
+
+	lockFile := filepath.Join(dir, "a.lock")
+	content, err := os.ReadFile(lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := server.URL + "/stale"
+	write("a.lock", string(content)+"  "+stale+": sha256:0\n")
+	if _, err := build(a, true); err == nil || !strings.Contains(err.Error(), "no longer used: "+stale) {
+		t.Fatalf("expected a stale lock error, got %v", err)
+	}
+	if _, err := build(a, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := build(a, true); err != nil {
+		t.Fatalf("expected the stale entry to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"do3b/xltemplate/api/loader"
+	"do3b/xltemplate/api/values"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// TestPatternDefaultsMerge pins that the defaults of later patterns override
+// the ones of earlier patterns whatever the strategy, the list options
+// still applying.
+func TestPatternDefaultsMerge(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("first/values.yaml", "a: first\nl: [1]\n")
+	write("second/values.yaml", "a: second\nl: [2]\n")
+	source := write("source.tmpl", "{{ .a }} {{ .l }}")
+
+	tests := []struct {
+		name  string
+		merge values.MergeOptions
+		want  string
+	}{
+		{name: "default", want: "second [2]"},
+		{name: "keep", merge: values.MergeOptions{Strategy: values.StrategyKeep}, want: "second [2]"},
+		{name: "append", merge: values.MergeOptions{Lists: values.ListAppend}, want: "second [1 2]"},
+		{
+			name:  "keep and append",
+			merge: values.MergeOptions{Strategy: values.StrategyKeep, Lists: values.ListAppend},
+			want:  "second [1 2]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := buildFlags{
+				Patterns: []patternRef{{Source: filepath.Join(dir, "first")}, {Source: filepath.Join(dir, "second")}},
+				Merge:    test.merge,
+				Targets:  []buildTarget{{Name: "test", Source: source}},
+			}
+			var output bytes.Buffer
+			if err := run(opts, loader.RemoteOptions{}, filesys.MakeFsOnDisk(), &output); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.want {
+				t.Errorf("got %q, want %q", output.String(), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package build
+
+import (
+	"do3b/xltemplate/api/loader"
+	"do3b/xltemplate/api/schema"
+	"do3b/xltemplate/api/templateengine"
+	"do3b/xltemplate/api/values"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Files at the root of a pattern directory holding default values for
+// the variables, rather than templates. Values files are looked up in
+// this order and only the first one found is used.
+var (
+	patternValuesFiles = []string{"values.yaml", "values.yml", "values.json", "values.toml"}
+	patternSchemaFile  = "values.schema.json"
+)
+
+// patternFilesDir is the directory of a pattern directory holding the
+// files which are not templates, read with .Files.
+const patternFilesDir = "files"
+
+// aliasPattern matches the valid aliases of the patterns.
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// patternRef references a pattern directory. It is written either as
+// its source, or as a map with the source and the alias namespacing
+// its templates, e.g. {source: ..., as: corp} for "corp/header".
+type patternRef struct {
+	Source string `yaml:"source"`
+	As     string `yaml:"as"`
+}
+
+func (r *patternRef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&r.Source); err == nil {
+		return nil
+	}
+	type plain patternRef
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	if r.As != "" && !aliasPattern.MatchString(r.As) {
+		return fmt.Errorf("invalid alias %q of pattern %s: only letters, digits, '_' and '-' are allowed", r.As, r.Source)
+	}
+	return nil
+}
+
+func (r patternRef) String() string {
+	if r.As == "" {
+		return r.Source
+	}
+	return r.As + "=" + r.Source
+}
+
+// patternsFlag implements pflag.Value, appending a pattern per flag
+// given as its source, or as alias=source.
+type patternsFlag struct {
+	patterns *[]patternRef
+}
+
+func (f patternsFlag) String() string {
+	if f.patterns == nil || len(*f.patterns) == 0 {
+		return ""
+	}
+	refs := make([]string, len(*f.patterns))
+	for i, ref := range *f.patterns {
+		refs[i] = ref.String()
+	}
+	return "[" + strings.Join(refs, ",") + "]"
+}
+
+func (f patternsFlag) Set(value string) error {
+	ref := patternRef{Source: value}
+	// URLs may hold '=' too, e.g. in ?ref=main, but not before a valid alias.
+	if alias, source, found := strings.Cut(value, "="); found && aliasPattern.MatchString(alias) {
+		ref = patternRef{Source: source, As: alias}
+	}
+	*f.patterns = append(*f.patterns, ref)
+	return nil
+}
+
+func (f patternsFlag) Type() string {
+	return "stringArray"
+}
+
+// loadPatterns creates the loaders of the patterns, fetching up to jobs
+// remote patterns concurrently, or one per CPU if jobs is not positive.
+// The loaders are returned in the order of the patterns, nil for the
+// failed ones, along with every failure.
+func loadPatterns(
+	patterns []patternRef, jobs int, fileSystem filesys.FileSystem,
+	remote loader.RemoteOptions) ([]*loader.FileLoader, error) {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	loaders := make([]*loader.FileLoader, len(patterns))
+	errs := make([]error, len(patterns))
+	semaphore := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, pattern := range patterns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			slog.Debug("Fetching pattern", "pattern", pattern.Source)
+			var err error
+			loaders[i], err = loader.NewLoaderWithOptions(loader.RestrictionNone, pattern.Source, fileSystem, remote)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to load pattern %s: %w", pattern.Source, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return loaders, errors.Join(errs...)
+}
+
+func isPatternDefaultsFile(name string) bool {
+	return name == patternSchemaFile || slices.Contains(patternValuesFiles, name)
+}
+
+// loadPatternDefaults returns the default values shipped with a pattern:
+// the defaults declared in its values.schema.json, overridden by its values
+// file. Both are optional.
+func loadPatternDefaults(patternLoader *loader.FileLoader, fileSystem filesys.FileSystem) (map[string]interface{}, error) {
+	defaults := map[string]interface{}{}
+
+	if fileSystem.Exists(filepath.Join(patternLoader.Root(), patternSchemaFile)) {
+		data, err := patternLoader.Load(patternSchemaFile)
+		if err != nil {
+			return nil, err
+		}
+		document, err := values.Decode(data, values.FormatJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", patternSchemaFile, err)
+		}
+		defaults = schema.Defaults(document)
+	}
+
+	for _, name := range patternValuesFiles {
+		if !fileSystem.Exists(filepath.Join(patternLoader.Root(), name)) {
+			continue
+		}
+		data, err := patternLoader.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		patternValues, err := values.Decode(data, values.FormatFromPath(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		if err := values.Merge(defaults, patternValues, values.MergeOptions{}); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	slog.Debug("Pattern defaults", "pattern", patternLoader.Root(), "defaults", defaults)
+	return defaults, nil
+}
+
+// loadPatternFiles adds the files of the files directory of a pattern to
+// files, named after their path in the directory, prefixed by the alias
+// of the pattern if any. They are read on demand, restricted to the
+// directory, so that a symbolic link can't read a file outside of it.
+func loadPatternFiles(
+	files *templateengine.Files, pattern patternRef,
+	patternLoader *loader.FileLoader, fileSystem filesys.FileSystem) error {
+	dir := filepath.Join(patternLoader.Root(), patternFilesDir)
+	if !fileSystem.IsDir(dir) {
+		return nil
+	}
+	root, err := filesys.ConfirmDir(fileSystem, dir)
+	if err != nil {
+		return err
+	}
+	return fileSystem.Walk(root.String(), func(path string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(root.String(), path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relativePath)
+		if pattern.As != "" {
+			name = pattern.As + "/" + name
+		}
+		files.Add(name, func() ([]byte, error) {
+			restrictedPath, err := loader.RestrictionRootOnly(fileSystem, root, path)
+			if err != nil {
+				return nil, err
+			}
+			return fileSystem.ReadFile(restrictedPath)
+		})
+		return nil
+	})
+}
+
+func recursivelyReadPatternDirectory(path string, dirEntry fs.DirEntry, patterns []string) []string {
+	fileInfo, err := dirEntry.Info()
+	if err != nil {
+		slog.Error("Error getting file info", "error", err)
+	}
+
+	if fileInfo.IsDir() {
+		return append(patterns, readDirectory(path+"/"+fileInfo.Name(), false)...)
+	} else {
+		return append(patterns, path+"/"+fileInfo.Name())
+	}
+}
+
+// readPatternDirectory returns the template files of a pattern directory,
+// without the defaults files and the files directory at its root.
+func readPatternDirectory(path string) []string {
+	return readDirectory(path, true)
+}
+
+func readDirectory(path string, root bool) []string {
+	patternFolder, err := os.Open(path)
+	if err != nil {
+		slog.Error("Error opening pattern directory", "error", err)
+	}
+	defer patternFolder.Close()
+
+	patterns, err := patternFolder.ReadDir(-1)
+	if err != nil {
+		slog.Error("Error reading pattern directory", "error", err)
+	}
+
+	var parsedFiles []string
+	for _, pattern := range patterns {
+		if root && (isPatternDefaultsFile(pattern.Name()) || pattern.IsDir() && pattern.Name() == patternFilesDir) {
+			continue
+		}
+		parsedFiles = recursivelyReadPatternDirectory(path, pattern, parsedFiles)
+	}
+
+	return parsedFiles
+}
@@ -0,0 +1,76 @@
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"do3b/xltemplate/api/loader"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// TestPatternDirectory pins that the defaults files and the files directory
+// are only set apart at the root of a pattern, nested ones being templates.
+func TestPatternDirectory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("lib/values.yaml", "a: default\n")
+	write("lib/files/data.txt", "{{ not a template")
+	write("lib/sub/values.yaml", `{{ define "subvalues" }}subvalues{{ end }}`)
+	write("lib/sub/files/n.tmpl", `{{ define "nested" }}nested{{ end }}`)
+	source := write("source.tmpl", `{{ include "nested" . }} {{ include "subvalues" . }} {{ .a }} {{ .Files.Get "data.txt" }}`)
+
+	opts := buildFlags{
+		Patterns: []patternRef{{Source: filepath.Join(dir, "lib")}},
+		Targets:  []buildTarget{{Name: "test", Source: source}},
+	}
+	var output bytes.Buffer
+	if err := run(opts, loader.RemoteOptions{}, filesys.MakeFsOnDisk(), &output); err != nil {
+		t.Fatal(err)
+	}
+	want := "nested subvalues default {{ not a template"
+	if output.String() != want {
+		t.Errorf("got %q, want %q", output.String(), want)
+	}
+}
+
+// TestPatternFilesData pins that the data of the templates only hold the
+// files when a pattern has some.
+func TestPatternFilesData(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	variables := filepath.Join(dir, "variables.yaml")
+	source := filepath.Join(dir, "source.tmpl")
+	if err := os.WriteFile(variables, []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(source, []byte("{{ toJson . }}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := buildFlags{
+		Patterns:  []patternRef{{Source: filepath.Join(dir, "lib")}},
+		Variables: variablesRef{File: variables},
+		Targets:   []buildTarget{{Name: "test", Source: source}},
+	}
+	var output bytes.Buffer
+	if err := run(opts, loader.RemoteOptions{}, filesys.MakeFsOnDisk(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":1}`; output.String() != want {
+		t.Errorf("got %q, want %q", output.String(), want)
+	}
+}
@@ -0,0 +1,343 @@
+package build
+
+import (
+	"do3b/xltemplate/api/git"
+	"do3b/xltemplate/api/loader"
+	"do3b/xltemplate/api/values"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Maximum number of nested :includes, which also bounds
+// cycles going through different clones of a repository.
+const maxIncludeDepth = 16
+
+// variablesRef references a variables file. It is written either as
+// the path of the file, or as a map with the file and its format.
+type variablesRef struct {
+	File   string `yaml:"file"`
+	Format string `yaml:"format"`
+}
+
+func (r *variablesRef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&r.File); err == nil {
+		return nil
+	}
+	type plain variablesRef
+	return unmarshal((*plain)(r))
+}
+
+// String, Set and Type implement pflag.Value, setting the file.
+func (r *variablesRef) String() string {
+	return r.File
+}
+
+func (r *variablesRef) Set(file string) error {
+	r.File = file
+	return nil
+}
+
+func (r *variablesRef) Type() string {
+	return "string"
+}
+
+// includeSpec is an :includes entry. It is either the path of the
+// file, or a map with the file, its format and its own merge options.
+type includeSpec struct {
+	File                string `yaml:"file"`
+	Format              string `yaml:"format"`
+	values.MergeOptions `yaml:",inline"`
+}
+
+// variablesFile locates a variables file.
+type variablesFile struct {
+	// Loader used to load the file and to clone the
+	// repositories of its includes.
+	loader *loader.FileLoader
+
+	// Absolute path of the file, or URL of a remote file.
+	path string
+
+	// Path of the file as written by the user.
+	name string
+
+	// Format of the file, guessed from its extension if empty.
+	format values.Format
+}
+
+// includeChain lists the variables files being loaded,
+// from the top-level variables file to the current include.
+type includeChain []variablesFile
+
+func (c includeChain) String() string {
+	names := make([]string, len(c))
+	for i, file := range c {
+		names[i] = file.name
+	}
+	return strings.Join(names, " -> ")
+}
+
+func (c includeChain) contains(file variablesFile) bool {
+	for _, visited := range c {
+		if visited.path == file.path {
+			return true
+		}
+	}
+	return false
+}
+
+// loadVariables loads a variables file and recursively merges its
+// :includes. Includes are merged in order on top of each other with the
+// merge options, then the including file is merged on top of them. Every loaded file is
+// added to the origins. An empty path yields no variables.
+func loadVariables(
+	ref variablesRef, mergeOptions values.MergeOptions, origins *values.Origins,
+	fileSystem filesys.FileSystem, remote loader.RemoteOptions) (map[string]interface{}, error) {
+	if ref.File == "" {
+		return map[string]interface{}{}, nil
+	}
+	path := ref.File
+	format, err := values.ParseFormat(ref.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	variables_loader, err := loader.NewLoaderWithOptions(
+		loader.RestrictionNone,
+		path,
+		fileSystem,
+		remote,
+	)
+	if err != nil {
+		slog.Error("Error loading variables", "error", err)
+		return nil, err
+	}
+	defer variables_loader.Cleanup()
+
+	file := variablesFile{loader: variables_loader, path: variables_loader.FilePath, name: path, format: format}
+	if !loader.IsRemoteFile(file.path) {
+		file.path = filepath.Join(variables_loader.Root(), variables_loader.FilePath)
+	}
+	variables, err := loadVariablesFile(file, mergeOptions, origins, nil)
+	if err != nil {
+		slog.Error("Error loading variables", "error", err)
+		return nil, err
+	}
+	slog.Debug("Merged variables", "variables", variables)
+	return variables, nil
+}
+
+// loadVariablesFile loads a variables file and its includes, the
+// chain holding the files which included it.
+func loadVariablesFile(
+	file variablesFile, mergeOptions values.MergeOptions, origins *values.Origins,
+	chain includeChain) (map[string]interface{}, error) {
+	if chain.contains(file) {
+		return nil, fmt.Errorf("include cycle detected: %s", append(chain, file))
+	}
+	chain = append(chain, file)
+	if len(chain) > maxIncludeDepth {
+		return nil, fmt.Errorf("maximum include depth of %d exceeded: %s", maxIncludeDepth, chain)
+	}
+
+	variables, err := loadVariablesData(file)
+	if err != nil {
+		if len(chain) == 1 {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to load %s (%s): %w", file.name, chain, err)
+	}
+
+	variable, exists := variables[":includes"]
+	if !exists {
+		origins.Add(chain.String(), variables)
+		return variables, nil
+	}
+	slog.Debug("Includes found in variables", "file", file.name, ":includes", variable)
+	includes, err := parseIncludes(variable)
+	if err != nil {
+		return nil, fmt.Errorf("invalid :includes in %s: %w", chain, err)
+	}
+	delete(variables, ":includes")
+
+	merged := map[string]interface{}{}
+	for _, include := range includes {
+		includedVariables, err := loadInclude(file, include, mergeOptions, origins, chain)
+		if err != nil {
+			return nil, err
+		}
+		if err := values.Merge(merged, includedVariables, mergeOptions.With(include.MergeOptions)); err != nil {
+			return nil, fmt.Errorf("failed to merge included variables %s: %w", include.File, err)
+		}
+	}
+	origins.Add(chain.String(), variables)
+	// The strategy only applies between includes, the including file wins.
+	if err := values.Merge(merged, variables, overriding(mergeOptions)); err != nil {
+		return nil, fmt.Errorf("failed to merge variables %s: %w", file.name, err)
+	}
+	return merged, nil
+}
+
+// overriding returns the merge options of a variables source merged on
+// top of the lower ones, which always wins whatever the strategy.
+func overriding(mergeOptions values.MergeOptions) values.MergeOptions {
+	return mergeOptions.With(values.MergeOptions{Strategy: values.StrategyOverride})
+}
+
+// loadInclude resolves an include relative to the file including it and
+// loads it. Git URLs are cloned by a child loader, which restricts loads
+// to the clone, detects repository cycles and is cleaned up afterwards.
+func loadInclude(
+	parent variablesFile, spec includeSpec, mergeOptions values.MergeOptions,
+	origins *values.Origins, chain includeChain) (map[string]interface{}, error) {
+	include := spec.File
+	file := variablesFile{loader: parent.loader, path: include, name: include, format: values.Format(spec.Format)}
+
+	if _, err := git.NewRepoSpecFromURL(include); err == nil {
+		repoLoader, err := parent.loader.New(include)
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone %s (%s): %w", include, chain, err)
+		}
+		defer repoLoader.Cleanup()
+		file.loader = repoLoader
+		file.path = filepath.Join(repoLoader.Root(), repoLoader.FilePath)
+	} else if loader.IsRemoteFile(parent.path) {
+		file.path = resolveURL(parent.path, include)
+	} else if !filepath.IsAbs(include) && !loader.IsRemoteFile(include) {
+		file.path = filepath.Join(filepath.Dir(parent.path), include)
+	}
+
+	return loadVariablesFile(file, mergeOptions, origins, chain)
+}
+
+// parseIncludes converts the decoded :includes value to include specs.
+func parseIncludes(variable interface{}) ([]includeSpec, error) {
+	list, ok := variable.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf(":includes must be a list")
+	}
+
+	includes := []includeSpec{}
+	for _, item := range list {
+		include := includeSpec{}
+		switch typed := item.(type) {
+		case string:
+			include.File = typed
+		case map[string]interface{}:
+			data, err := yaml.Marshal(typed)
+			if err != nil {
+				return nil, err
+			}
+			if err := yaml.UnmarshalStrict(data, &include); err != nil {
+				return nil, fmt.Errorf("invalid include %v: %w", typed, err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid include %v: must be a path or a map", item)
+		}
+		if include.File == "" {
+			return nil, fmt.Errorf("invalid include %v: file is required", item)
+		}
+		format, err := values.ParseFormat(include.Format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %s: %w", include.File, err)
+		}
+		include.Format = string(format)
+		if err := include.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid include %s: %w", include.File, err)
+		}
+		includes = append(includes, include)
+	}
+	return includes, nil
+}
+
+// loadEnvVariables builds variables from the entries of the .env files
+// and, if a prefix is set, from the environment variables, the latter
+// taking precedence.
+func loadEnvVariables(env envConfig, fileSystem filesys.FileSystem) (map[string]interface{}, error) {
+	var environ []string
+	for _, file := range env.Files {
+		data, err := fileSystem.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file: %w", err)
+		}
+		entries, err := values.ParseDotEnv(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse env file %s: %w", file, err)
+		}
+		environ = append(environ, entries...)
+	}
+	if env.Prefix != "" {
+		environ = append(environ, os.Environ()...)
+	}
+
+	variables, err := values.FromEnv(environ, env.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Variables from environment", "variables", variables)
+	return variables, nil
+}
+
+// applySetValues sets the --set, --set-string and --set-file values
+// in variables, in this order so the latter take precedence.
+func applySetValues(opts buildFlags, variables map[string]interface{}) error {
+	for _, set := range opts.SetValues {
+		if err := values.ParseSet(set, variables, false); err != nil {
+			return fmt.Errorf("invalid --set %q: %w", set, err)
+		}
+	}
+	for _, set := range opts.SetStringValues {
+		if err := values.ParseSet(set, variables, true); err != nil {
+			return fmt.Errorf("invalid --set-string %q: %w", set, err)
+		}
+	}
+	for _, set := range opts.SetFileValues {
+		if err := values.ParseSetFile(set, variables, os.ReadFile); err != nil {
+			return fmt.Errorf("invalid --set-file %q: %w", set, err)
+		}
+	}
+	slog.Debug("Variables after command line overrides", "variables", variables)
+	return nil
+}
+
+// loadVariablesData loads a variables file through its loader and
+// decodes it according to its format.
+func loadVariablesData(file variablesFile) (map[string]interface{}, error) {
+	data, err := file.loader.Load(file.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	format := file.format
+	if format == "" {
+		format = values.FormatFromPath(file.path)
+	}
+	return values.Decode(data, format)
+}
+
+// resolveURL returns path relative to the baseURL, unless it is absolute
+// or a URL itself.
+func resolveURL(baseURL string, path string) string {
+	if filepath.IsAbs(path) || loader.IsRemoteFile(path) {
+		return path
+	}
+	if _, err := git.NewRepoSpecFromURL(path); err == nil {
+		return path
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return path
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,56 @@
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"do3b/xltemplate/api/loader"
+	"do3b/xltemplate/api/values"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// TestVariablesPrecedence pins the precedence of the variables, from the
+// lowest to the highest: includes, the variables file, the environment,
+// the target variables file and --set, whatever the merge strategy.
+func TestVariablesPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("include.yaml", "a: include\nb: include\nc: include\nd: include\ne: include\n")
+	variablesFile := write("variables.yaml", ":includes:\n- include.yaml\nb: file\nc: file\nd: file\ne: file\n")
+	targetFile := write("target.yaml", "d: target\ne: target\n")
+	source := write("source.tmpl", "a={{ .a }} b={{ .b }} c={{ .c }} d={{ .d }} e={{ .e }}")
+	t.Setenv("XLT_TEST_c", "env")
+	t.Setenv("XLT_TEST_d", "env")
+	t.Setenv("XLT_TEST_e", "env")
+
+	for _, strategy := range []values.Strategy{"", values.StrategyOverride, values.StrategyKeep} {
+		t.Run(string(strategy), func(t *testing.T) {
+			opts := buildFlags{
+				Variables: variablesRef{File: variablesFile},
+				Env:       envConfig{Prefix: "XLT_TEST_"},
+				Merge:     values.MergeOptions{Strategy: strategy},
+				Targets: []buildTarget{
+					{Name: "test", Source: source, Variables: variablesRef{File: targetFile}},
+				},
+				SetValues: []string{"e=set"},
+			}
+			var output bytes.Buffer
+			if err := run(opts, loader.RemoteOptions{}, filesys.MakeFsOnDisk(), &output); err != nil {
+				t.Fatal(err)
+			}
+			want := "a=include b=file c=env d=target e=set"
+			if output.String() != want {
+				t.Errorf("got %q, want %q", output.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package build
+
+import (
+	"do3b/xltemplate/api/vendoring"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// NewCmdVendor makes a new vendor command, copying the remote
+// references of an xltemplate file into its vendor directory.
+func NewCmdVendor(fileSystem filesys.FileSystem, w io.Writer) *cobra.Command {
+	opts := buildFlags{}
+
+	cmd := cobra.Command{
+		Use:   "vendor [xltemplate file]",
+		Short: "Copy the remote references into the vendor directory",
+		Long: `Copy every remote source, pattern, variables file, include and schema of
+an xltemplate file into its directory of the vendor/ directory next to it,
+e.g. vendor/xltemplate for xltemplate.yaml, so that 'xltemplate build
+--offline' builds it without network access.`,
+		Example:      `xltemplate vendor xltemplate.yaml`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			xltemplateFile := "xltemplate.yaml"
+			if len(args) > 0 {
+				xltemplateFile = args[0]
+			}
+			if err := mergeXltemplateFile(&opts, xltemplateFile); err != nil {
+				return err
+			}
+			opts.resolveOnly = true
+			return runVendor(opts, fileSystem, w)
+		},
+	}
+
+	addRemoteFlags(&cmd, &opts)
+	return &cmd
+}
+
+// runVendor loads every remote reference of the build, copying them
+// into the vendor directory in place of the previous ones, once all
+// of them are loaded.
+func runVendor(opts buildFlags, fileSystem filesys.FileSystem, w io.Writer) error {
+	buildLock, err := loadLock(opts)
+	if err != nil {
+		return err
+	}
+	vendor, err := vendoring.Create(opts.vendorDirectory())
+	if err != nil {
+		return err
+	}
+	defer vendor.Cleanup()
+	remote, err := newRemoteOptions(opts, buildLock)
+	if err != nil {
+		return err
+	}
+	remote.Cloner = vendor.Recorder(remote.Cloner)
+	remote.HTTPClient = vendor.RecordingHTTPClient(remote.HTTPClient)
+
+	if err := run(opts, remote, fileSystem, io.Discard); err != nil {
+		return err
+	}
+	if err := vendor.Save(); err != nil {
+		return err
+	}
+	if err := saveLock(opts, buildLock); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Vendored remote references in %s\n", vendor.Dir())
+	return nil
+}
@@ -0,0 +1,63 @@
+package build
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// TestVendorPerXltemplateFile pins that vendoring an xltemplate file
+// leaves the vendored references of the others of its directory.
+func TestVendorPerXltemplateFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v: " + strings.TrimPrefix(r.URL.Path, "/") + "\n"))
+	}))
+	t.Setenv("XLTEMPLATE_CREDENTIALS", filepath.Join(t.TempDir(), "credentials.yaml"))
+
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("source.tmpl", "{{ .v }}")
+	a := write("a.yaml", "source: source.tmpl\nvariables: "+server.URL+"/a\n")
+	b := write("b.yaml", "source: source.tmpl\nvariables: "+server.URL+"/b\n")
+	options := func(file string) buildFlags {
+		opts := buildFlags{NoCache: true}
+		if err := mergeXltemplateFile(&opts, file); err != nil {
+			t.Fatal(err)
+		}
+		return opts
+	}
+
+	for _, file := range []string{a, b} {
+		opts := options(file)
+		opts.resolveOnly = true
+		if err := runVendor(opts, filesys.MakeFsOnDisk(), io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Close()
+
+	for file, want := range map[string]string{a: "a", b: "b"} {
+		opts := options(file)
+		opts.Offline = true
+		var output bytes.Buffer
+		if err := Run(opts, filesys.MakeFsOnDisk(), &output); err != nil {
+			t.Fatal(err)
+		}
+		if output.String() != want {
+			t.Errorf("got %q, want %q", output.String(), want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"do3b/xltemplate/api/git"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultPruneAge = 30 * 24 * time.Hour
+
+// NewCmdCache makes a new cache command, managing the
+// clones of git repositories kept between builds.
+func NewCmdCache(w io.Writer) *cobra.Command {
+	cacheCmd := cobra.Command{
+		Use:   "cache",
+		Short: "Manage the cache of git repositories",
+		Long: `Manage the cache of git repositories. Clones are stored in
+$XDG_CACHE_HOME/xltemplate, keyed by host, repository and commit.`,
+	}
+	cacheCmd.AddCommand(
+		newCmdList(w),
+		newCmdPrune(w),
+		newCmdClear(w),
+	)
+	return &cacheCmd
+}
+
+func newCmdList(w io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:          "list",
+		Short:        "List the cached repositories",
+		Example:      `xltemplate cache list`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := defaultCache()
+			if err != nil {
+				return err
+			}
+			entries, err := cache.List()
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "HOST\tREPOSITORY\tCOMMIT\tLAST USED\tSIZE")
+			for _, entry := range entries {
+				commit := entry.Commit
+				if entry.Sparse {
+					commit += " (sparse)"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+					entry.Host, entry.RepoPath, commit,
+					entry.LastUsed.Format(time.DateTime), formatSize(entry.Size))
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func newCmdPrune(w io.Writer) *cobra.Command {
+	var unusedFor time.Duration
+	cmd := cobra.Command{
+		Use:          "prune",
+		Short:        "Remove the cached repositories not used recently",
+		Example:      `xltemplate cache prune --unused-for 168h`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := defaultCache()
+			if err != nil {
+				return err
+			}
+			pruned, err := cache.Prune(unusedFor)
+			for _, entry := range pruned {
+				fmt.Fprintf(w, "Removed %s/%s@%s\n", entry.Host, entry.RepoPath, entry.Commit)
+			}
+			return err
+		},
+	}
+	cmd.Flags().DurationVar(&unusedFor, "unused-for", defaultPruneAge, "remove the repositories not used for this duration")
+	return &cmd
+}
+
+func newCmdClear(w io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:          "clear",
+		Short:        "Remove every cached repository",
+		Example:      `xltemplate cache clear`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache, err := defaultCache()
+			if err != nil {
+				return err
+			}
+			if err := cache.Clear(); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Cleared %s\n", cache.Dir)
+			return nil
+		},
+	}
+}
+
+func defaultCache() (*git.Cache, error) {
+	dir, err := git.DefaultCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate cache directory: %w", err)
+	}
+	return git.NewCache(dir), nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -4,8 +4,11 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"do3b/xltemplate/api/redact"
 	"do3b/xltemplate/cmd/build"
+	"do3b/xltemplate/cmd/cache"
 	"do3b/xltemplate/cmd/version"
+	"log"
 	"log/slog"
 	"os"
 
@@ -25,6 +28,12 @@ from a source file, patterns and a set of variables.`,
 		if Verbose {
 			slog.SetLogLoggerLevel(slog.LevelDebug)
 		}
+		// Hide the secrets of the credentials from the logs. SetDefault
+		// routes the log package to the handler, which writes through
+		// it, restore its output to avoid a loop.
+		slog.SetDefault(slog.New(redact.NewHandler(slog.Default().Handler())))
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
 	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
@@ -38,10 +47,16 @@ func Execute() {
 
 	rootCmd.AddCommand(
 		build.NewCmdVersion(fileSystem, os.Stdout),
+		build.NewCmdLock(fileSystem, os.Stdout),
+		build.NewCmdVendor(fileSystem, os.Stdout),
 		version.NewCmdVersion(os.Stdout),
+		cache.NewCmdCache(os.Stdout),
 	)
+	// Errors are printed here to hide their secrets.
+	rootCmd.SilenceErrors = true
 	err := rootCmd.Execute()
 	if err != nil {
+		rootCmd.PrintErrln(rootCmd.ErrPrefix(), redact.String(err.Error()))
 		os.Exit(1)
 	}
 }